@@ -0,0 +1,78 @@
+package database
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// databasesFile is the config file listing the named databases, e.g.
+//
+//	{
+//	  "analytics": {
+//	    "url": "analytics.db",
+//	    "migrations": "internal/analytics/migrations"
+//	  }
+//	}
+//
+// URLs can reference environment variables as $VAR or ${VAR}.
+var databasesFile = filepath.Join(".leapkit", "databases.json")
+
+// databaseName is the named database commands operate on
+var databaseName string
+
+// configFlags registers the --db flag on fs.
+func configFlags(fs *flag.FlagSet) {
+	fs.StringVar(&databaseName, "db", "", "the named database to operate on, from "+databasesFile+" or DATABASE_URL_<NAME>")
+}
+
+// databaseConfig is a named database in the databases file.
+type databaseConfig struct {
+	URL        string `json:"url"`
+	Migrations string `json:"migrations"`
+	Seeds      string `json:"seeds"`
+}
+
+// resolveDatabase returns the URL of the database to operate on.
+// Without a name it is DATABASE_URL. Named databases come from the
+// databases file or from DATABASE_URL_<NAME>, and point the migrations
+// and seeds folders to theirs unless those flags were passed.
+func resolveDatabase(name string) (string, error) {
+	if name == "" {
+		return cmp.Or(os.Getenv("DATABASE_URL"), "database.db?_timeout=5000&_sync=1"), nil
+	}
+
+	configs := map[string]databaseConfig{}
+	content, err := os.ReadFile(databasesFile)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("error reading %s: %w", databasesFile, err)
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(content, &configs); err != nil {
+			return "", fmt.Errorf("error parsing %s: %w", databasesFile, err)
+		}
+	}
+
+	envName := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	config := configs[name]
+	config.URL = cmp.Or(os.ExpandEnv(config.URL), os.Getenv("DATABASE_URL_"+envName))
+	if config.URL == "" {
+		return "", fmt.Errorf("database %q not found in %s or DATABASE_URL_%s", name, databasesFile, envName)
+	}
+
+	if !commandLine.Changed("migration.folder") {
+		migrationFolder = cmp.Or(config.Migrations, filepath.Join("internal", name, "migrations"))
+	}
+
+	if !commandLine.Changed("seed.folder") {
+		seedFolder = cmp.Or(config.Seeds, filepath.Join("internal", name, "seeds"))
+	}
+
+	return config.URL, nil
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+)
+
+// openConsole opens an interactive SQL shell on the database at url.
+// It runs the native client of the dialect when it is installed, and
+// the built-in console otherwise.
+func openConsole(url string) error {
+	d, err := dialectFor(url)
+	if err != nil {
+		return err
+	}
+
+	if d.Console != nil {
+		cmd, err := d.Console(url)
+		if err != nil {
+			return err
+		}
+
+		if _, err := exec.LookPath(cmd.Path); err == nil {
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+
+			return cmd.Run()
+		}
+
+		fmt.Printf("%s not found in PATH, using the built-in console\n", cmd.Args[0])
+	}
+
+	conn, _, err := openConn(url)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	return runConsole(conn, os.Stdin, os.Stdout)
+}
+
+// runConsole reads statements from in, each ending with a semicolon,
+// runs them against conn and prints their results to out as tables.
+// It returns when in is done or on \q, exit and quit.
+func runConsole(conn *sql.DB, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	var stmt strings.Builder
+
+	fmt.Fprint(out, "db> ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if stmt.Len() == 0 {
+			switch line {
+			case `\q`, "exit", "quit":
+				return nil
+			}
+		}
+
+		if line != "" {
+			stmt.WriteString(line)
+			stmt.WriteString("\n")
+		}
+
+		if !strings.HasSuffix(line, ";") {
+			if stmt.Len() == 0 {
+				fmt.Fprint(out, "db> ")
+			} else {
+				fmt.Fprint(out, "..> ")
+			}
+
+			continue
+		}
+
+		if err := runStatement(conn, stmt.String(), out); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		}
+
+		stmt.Reset()
+		fmt.Fprint(out, "db> ")
+	}
+
+	fmt.Fprintln(out)
+	return scanner.Err()
+}
+
+// runStatement runs stmt and prints the rows it returns, or the
+// number of rows it affected when it returns none.
+func runStatement(conn *sql.DB, stmt string, out io.Writer) error {
+	if !returnsRows(stmt) {
+		result, err := conn.Exec(stmt)
+		if err != nil {
+			return err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			fmt.Fprintln(out, "OK")
+			return nil
+		}
+
+		fmt.Fprintf(out, "OK, %d row(s) affected\n", affected)
+		return nil
+	}
+
+	rows, err := conn.Query(stmt)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	var count int
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		cells := make([]string, len(values))
+		for i, v := range values {
+			cells[i] = formatValue(v)
+		}
+
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "(%d row(s))\n", count)
+	return nil
+}
+
+// returnsRows reports whether stmt is a query returning rows, going
+// by its first keyword or a RETURNING clause.
+func returnsRows(stmt string) bool {
+	fields := strings.Fields(strings.ToUpper(stmt))
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[0] {
+	case "SELECT", "WITH", "VALUES", "PRAGMA", "SHOW", "EXPLAIN", "DESCRIBE", "DESC", "TABLE":
+		return true
+	}
+
+	for _, field := range fields {
+		if field == "RETURNING" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatValue returns the console representation of a scanned value.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// postgresConsole runs psql for url, passing its password through
+// PGPASSWORD so it does not show up in the process list.
+func postgresConsole(url string) (*exec.Cmd, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing postgres url: %w", err)
+	}
+
+	cmd := exec.Command("psql")
+	if password, ok := u.User.Password(); ok {
+		cmd.Env = append(os.Environ(), "PGPASSWORD="+password)
+		u.User = neturl.User(u.User.Username())
+	}
+
+	cmd.Args = append(cmd.Args, u.String())
+	return cmd, nil
+}
+
+// sqliteConsole runs sqlite3 on the database file of url.
+func sqliteConsole(url string) (*exec.Cmd, error) {
+	path, _, _ := strings.Cut(strings.TrimPrefix(sqlitePath(url), "file:"), "?")
+	if path == "" {
+		return nil, errors.New("no database file in url")
+	}
+
+	return exec.Command("sqlite3", path), nil
+}
+
+// mysqlConsole runs the mysql client for url, passing its password
+// through MYSQL_PWD so it does not show up in the process list.
+func mysqlConsole(url string) (*exec.Cmd, error) {
+	cfg, err := mysqlConfig(url)
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing mysql address: %w", err)
+	}
+
+	cmd := exec.Command("mysql", "--host", host, "--port", port, "--user", cfg.User)
+	if cfg.Passwd != "" {
+		cmd.Env = append(os.Environ(), "MYSQL_PWD="+cfg.Passwd)
+	}
+
+	if cfg.DBName != "" {
+		cmd.Args = append(cmd.Args, cfg.DBName)
+	}
+
+	return cmd, nil
+}
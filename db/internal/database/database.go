@@ -1,93 +1,283 @@
 package database
 
 import (
-	"cmp"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	flag "github.com/spf13/pflag"
-	"go.leapkit.dev/core/db"
-
-	// Loading .env file
-	_ "go.leapkit.dev/core/tools/envload"
 
 	// Postgres driver
 	_ "github.com/lib/pq"
 
 	// Sqlite3 driver
 	_ "github.com/mattn/go-sqlite3"
+
+	// MySQL driver
+	_ "github.com/go-sql-driver/mysql"
 )
 
+// commandLine holds the flags Exec parsed.
+var commandLine *flag.FlagSet
+
+// newFlagSet returns the flags of the db commands with their
+// variables set to the defaults. They are registered by Exec rather
+// than on init so programs importing the package keep their own
+// flags.
+func newFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("db", flag.ContinueOnError)
+	fs.Usage = func() {}
+
+	for _, register := range []func(*flag.FlagSet){
+		configFlags, diffFlags, dryRunFlags, lockFlags, migrationFlags, outputFlags,
+		seedFlags, snapshotFlags, squashFlags, verifyFlags, waitFlags,
+	} {
+		register(fs)
+	}
+
+	return fs
+}
+
 // Exec provides operations to manage the database
-// during development. It can create, drop and run migrations.
+// during development. It can create, drop, run and roll back migrations.
 func Exec() error {
-	flag.Parse()
+	commandLine = newFlagSet()
+	err := commandLine.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		printHelp(os.Stdout)
+		return nil
+	}
 
-	args := os.Args
+	if err != nil {
+		return fmt.Errorf("%w, run `db help <command>` for the flags of a command", err)
+	}
 
-	if len(args) < 2 {
-		fmt.Println("Usage: database <command>")
+	args := commandLine.Args()
+	if len(args) == 0 {
+		printHelp(os.Stderr)
 
-		return nil
+		return fmt.Errorf("missing command, run `db help` to list the commands")
 	}
 
-	url := cmp.Or(os.Getenv("DATABASE_URL"), "database.db?_timeout=5000&_sync=1")
+	if args[0] == "help" {
+		return help(args[1:])
+	}
+
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		return fmt.Errorf("unknown command %q, run `db help` to list the commands", args[0])
+	}
+
+	if err := cmd.checkArgs(args[1:]); err != nil {
+		return err
+	}
+
+	switch outputFormat {
+	case "text":
+		return run(cmd.name, args[1:])
+	case "json":
+		// The text output is left out and the report printed
+		// instead, errors are still returned.
+		report = &operationReport{Operation: cmd.name, StartedAt: time.Now()}
+		defer func() { report = nil }()
+
+		err := run(cmd.name, args[1:])
+		if werr := report.write(os.Stdout, err); werr != nil && err == nil {
+			return werr
+		}
+
+		return err
+	default:
+		return fmt.Errorf("unknown output format %q, use text or json", outputFormat)
+	}
+}
 
-	switch args[1] {
+// run runs the command with its positional arguments.
+func run(name string, args []string) error {
+	url, err := resolveDatabase(databaseName)
+	if err != nil {
+		return err
+	}
+
+	report.setDatabase(url)
+
+	// Containers often start the app before the database is up.
+	if waitForReady && (name == "migrate" || name == "create" || name == "reset") {
+		if err := waitForDatabase(url, waitTimeout); err != nil {
+			return err
+		}
+	}
+
+	switch name {
 	case "migrate":
-		err := runMigrations(url)
+		if dryRun {
+			if report != nil {
+				return fmt.Errorf("--dry-run does not support --format json")
+			}
+
+			return dryRunMigrations(url, migrationTarget)
+		}
+
+		err := runMigrations(url, migrationTarget)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("✅ Migrations ran successfully")
+		printf("✅ Migrations ran successfully\n")
+	case "rollback":
+		err := rollbackMigrations(url, rollbackSteps)
+		if err != nil {
+			return err
+		}
+
+		printf("✅ Rollback ran successfully\n")
+	case "status":
+		err := migrationStatus(url)
+		if err != nil {
+			return err
+		}
+
+		printf("✅ Database is up to date\n")
 	case "create":
-		err := db.Create(url)
+		err := createDatabase(url)
 		if err != nil {
 			return fmt.Errorf("error creating database: %w", err)
 		}
 
-		fmt.Println("✅ Database created successfully")
+		printf("✅ Database created successfully\n")
 
 	case "drop":
-		err := db.Drop(url)
+		err := dropDatabase(url)
 		if err != nil {
 			return fmt.Errorf("error dropping database: %w", err)
 		}
 
-		fmt.Println("✅ Database dropped successfully")
+		printf("✅ Database dropped successfully\n")
 
 	case "reset":
-		err := db.Drop(url)
+		err := dropDatabase(url)
 		if err != nil {
 			return fmt.Errorf("error dropping database: %w", err)
 		}
 
-		err = db.Create(url)
+		err = createDatabase(url)
 		if err != nil {
 			return fmt.Errorf("error creating database: %w", err)
 		}
 
-		if err := runMigrations(url); err != nil {
+		// Loading the schema is faster than replaying every
+		// migration, pending ones still run afterwards.
+		if hasSchemaFile() {
+			if err := loadSchema(url); err != nil {
+				return err
+			}
+		}
+
+		if err := runMigrations(url, ""); err != nil {
 			return err
 		}
 
-		fmt.Println("✅ Database reset successfully")
+		if seedAfterReset {
+			if err := runSeeds(url); err != nil {
+				return err
+			}
+		}
+
+		printf("✅ Database reset successfully\n")
+
+	case "verify":
+		if err := verifyMigrations(url); err != nil {
+			return err
+		}
+
+		printf("✅ Migrations match the migrations folder\n")
+
+	case "test:prepare":
+		testURL, err := prepareTestDatabase(url)
+		if err != nil {
+			return err
+		}
+
+		printf("✅ Test database %s prepared successfully\n", redactURL(testURL))
+
+	case "seed":
+		if err := runSeeds(url); err != nil {
+			return err
+		}
+
+		printf("✅ Database seeded successfully\n")
+
+	case "schema:dump":
+		if err := dumpSchema(url); err != nil {
+			return err
+		}
+
+		printf("✅ Schema dumped to %s\n", schemaFile())
+
+	case "schema:load":
+		if err := loadSchema(url); err != nil {
+			return err
+		}
+
+		printf("✅ Schema loaded from %s\n", schemaFile())
+
+	case "squash":
+		if err := squashMigrations(url, squashBefore); err != nil {
+			return err
+		}
+
+	case "diff":
+		if err := diffDatabase(url); err != nil {
+			return err
+		}
+
+	case "snapshot":
+		if err := snapshot(url, args[0], args[1]); err != nil {
+			return err
+		}
+
+		if args[0] == "save" {
+			printf("📸 Snapshot %s saved\n", args[1])
+		} else {
+			printf("✅ Snapshot %s restored\n", args[1])
+		}
+
+	case "wait":
+		if err := waitForDatabase(url, waitTimeout); err != nil {
+			return err
+		}
+
+		printf("✅ Database is accepting connections\n")
+
+	case "console":
+		if report != nil {
+			return fmt.Errorf("console does not support --format json")
+		}
+
+		if err := openConsole(url); err != nil {
+			return err
+		}
 
 	case "generate_migration":
-		if len(args) < 3 {
-			fmt.Println("Usage: database generate_migration <migration_name>")
-			return nil
+		d, err := dialectFor(url)
+		if err != nil {
+			return err
+		}
+
+		if goMigration && len(args) > 1 {
+			return fmt.Errorf("columns are only supported for SQL migrations")
 		}
 
-		err := newMigration(args[2])
+		// Column arguments come after the migration name.
+		err = newMigration(args[0], goMigration, func(name string) (string, string, error) {
+			return inferMigration(name, args[1:], d)
+		})
 		if err != nil {
 			return err
 		}
 	default:
-		fmt.Println("command not found")
-
-		return nil
+		return fmt.Errorf("unknown command %q, run `db help` to list the commands", name)
 	}
 
 	return nil
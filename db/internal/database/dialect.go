@@ -0,0 +1,309 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.leapkit.dev/core/db"
+)
+
+// Dialect describes how to work with a kind of database. Dialects
+// are registered for URL schemes with RegisterDialect.
+type Dialect struct {
+	// Driver is the database/sql driver name used to connect.
+	Driver string
+
+	// DSN converts the database URL into the data source name the
+	// driver expects. When nil the URL is used as is.
+	DSN func(url string) (string, error)
+
+	// Create and Drop create and drop the database at the URL.
+	// When nil the operation is not supported for the dialect.
+	Create func(url string) error
+	Drop   func(url string) error
+
+	// DumpSchema returns the SQL that recreates the schema of the
+	// database at the URL. When nil schema dumps are not supported.
+	DumpSchema func(url string, conn *sql.DB) (string, error)
+
+	// Lock takes a database wide lock so only one runner migrates
+	// at a time, retrying until ctx is done. It returns the function
+	// that releases the lock. When nil a lock table is used.
+	Lock func(ctx context.Context, conn *sql.DB) (unlock func() error, err error)
+
+	// Copy replaces the database at dst with a copy of the one at
+	// src, for snapshots. When nil snapshots are not supported.
+	Copy func(src, dst string) error
+
+	// Console returns the command opening the native client on the
+	// database at the URL. When nil, or when the client is not
+	// installed, db console falls back to a built-in one.
+	Console func(url string) (*exec.Cmd, error)
+
+	// Types maps the column types of generate_migration, such as
+	// string or integer, to the dialect's SQL types. PrimaryKey is the
+	// id column of new tables and DropIndex the statement dropping an
+	// index. Standard SQL is used for the ones left empty.
+	Types      map[string]string
+	PrimaryKey string
+	DropIndex  func(table, index string) string
+
+	// Placeholder returns the bind parameter for the nth argument
+	// of a query. When nil $1, $2... are used.
+	Placeholder func(n int) string
+}
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = map[string]Dialect{}
+
+	// schemeExp matches the scheme of a database URL. Single letters
+	// are left out so Windows paths are not taken for schemes.
+	schemeExp = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]+):`)
+)
+
+// RegisterDialect makes the dialect available for URLs with the
+// given scheme, replacing any dialect registered for it before.
+func RegisterDialect(scheme string, d Dialect) {
+	if d.Driver == "" {
+		panic(fmt.Sprintf("database: dialect for %q has no driver", scheme))
+	}
+
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+
+	dialects[strings.ToLower(scheme)] = d
+}
+
+func init() {
+	postgres := Dialect{
+		Driver:     "postgres",
+		Create:     db.Create,
+		Drop:       db.Drop,
+		DumpSchema: dumpPostgres,
+		Lock:       postgresLock,
+		Console:    postgresConsole,
+		Copy:       copyPostgres,
+		Types: map[string]string{
+			"string":    "VARCHAR(255)",
+			"text":      "TEXT",
+			"integer":   "INTEGER",
+			"bigint":    "BIGINT",
+			"float":     "DOUBLE PRECISION",
+			"decimal":   "NUMERIC",
+			"boolean":   "BOOLEAN",
+			"date":      "DATE",
+			"time":      "TIME",
+			"datetime":  "TIMESTAMP",
+			"timestamp": "TIMESTAMPTZ",
+			"uuid":      "UUID",
+			"json":      "JSONB",
+			"binary":    "BYTEA",
+		},
+		PrimaryKey: "id BIGSERIAL PRIMARY KEY",
+	}
+
+	RegisterDialect("postgres", postgres)
+	RegisterDialect("postgresql", postgres)
+
+	sqlite := Dialect{
+		Driver: "sqlite3",
+		DSN: func(url string) (string, error) {
+			return sqlitePath(url), nil
+		},
+		Create: func(url string) error {
+			return db.Create(sqlitePath(url))
+		},
+		Drop: func(url string) error {
+			return db.Drop(sqlitePath(url))
+		},
+		DumpSchema: dumpSQLite,
+		Console:    sqliteConsole,
+		Copy:       copySQLite,
+		Types:      sqliteTypes,
+		PrimaryKey: "id INTEGER PRIMARY KEY AUTOINCREMENT",
+	}
+
+	RegisterDialect("sqlite", sqlite)
+	RegisterDialect("sqlite3", sqlite)
+	RegisterDialect("file", sqlite)
+
+	mysql := Dialect{
+		Driver:      "mysql",
+		DSN:         mysqlDSN,
+		Create:      createMySQL,
+		Drop:        dropMySQL,
+		DumpSchema:  dumpMySQL,
+		Lock:        mysqlLock,
+		Console:     mysqlConsole,
+		Placeholder: func(int) string { return "?" },
+		Types: map[string]string{
+			"string":    "VARCHAR(255)",
+			"text":      "TEXT",
+			"integer":   "INT",
+			"bigint":    "BIGINT",
+			"float":     "DOUBLE",
+			"decimal":   "DECIMAL(10, 2)",
+			"boolean":   "BOOLEAN",
+			"date":      "DATE",
+			"time":      "TIME",
+			"datetime":  "DATETIME",
+			"timestamp": "TIMESTAMP",
+			"uuid":      "CHAR(36)",
+			"json":      "JSON",
+			"binary":    "BLOB",
+		},
+		PrimaryKey: "id BIGINT AUTO_INCREMENT PRIMARY KEY",
+		DropIndex: func(table, index string) string {
+			return fmt.Sprintf("DROP INDEX %s ON %s;", index, table)
+		},
+	}
+
+	RegisterDialect("mysql", mysql)
+	RegisterDialect("mariadb", mysql)
+
+	// libsql databases are created and dropped with the provider
+	// tooling. The driver is not bundled, binaries built with
+	// migrate.Exec can import it.
+	RegisterDialect("libsql", Dialect{
+		Driver:     "libsql",
+		Types:      sqliteTypes,
+		PrimaryKey: sqlite.PrimaryKey,
+	})
+}
+
+// sqliteTypes are the column types for SQLite and libsql.
+var sqliteTypes = map[string]string{
+	"string":    "TEXT",
+	"text":      "TEXT",
+	"integer":   "INTEGER",
+	"bigint":    "INTEGER",
+	"float":     "REAL",
+	"decimal":   "NUMERIC",
+	"boolean":   "BOOLEAN",
+	"date":      "DATE",
+	"time":      "TIME",
+	"datetime":  "DATETIME",
+	"timestamp": "TIMESTAMP",
+	"uuid":      "TEXT",
+	"json":      "TEXT",
+	"binary":    "BLOB",
+}
+
+// placeholderExp matches the $N placeholders in the bookkeeping queries.
+var placeholderExp = regexp.MustCompile(`\$(\d+)`)
+
+// rebind rewrites the $N placeholders in query to the ones the
+// dialect uses.
+func (d Dialect) rebind(query string) string {
+	if d.Placeholder == nil {
+		return query
+	}
+
+	return placeholderExp.ReplaceAllStringFunc(query, func(p string) string {
+		n, _ := strconv.Atoi(p[1:])
+		return d.Placeholder(n)
+	})
+}
+
+// dialectFor returns the dialect registered for the scheme of url.
+// URLs without a scheme are SQLite file paths.
+func dialectFor(url string) (Dialect, error) {
+	scheme := "sqlite"
+	if match := schemeExp.FindStringSubmatch(url); match != nil {
+		scheme = strings.ToLower(match[1])
+	}
+
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	d, ok := dialects[scheme]
+	if !ok {
+		return Dialect{}, fmt.Errorf("no database dialect registered for %q urls", scheme)
+	}
+
+	return d, nil
+}
+
+// sqlitePath turns sqlite:// and sqlite3:// URLs into the file path
+// go-sqlite3 expects. Plain paths and file: URIs are kept as they are.
+func sqlitePath(url string) string {
+	for _, prefix := range []string{"sqlite://", "sqlite3://"} {
+		if len(url) >= len(prefix) && strings.EqualFold(url[:len(prefix)], prefix) {
+			return url[len(prefix):]
+		}
+	}
+
+	return url
+}
+
+// sqliteExists reports whether the SQLite database at url exists.
+// In-memory databases always do.
+func sqliteExists(url string) bool {
+	name, query, _ := strings.Cut(strings.TrimPrefix(sqlitePath(url), "file:"), "?")
+	if name == "" || strings.Contains(name, ":memory:") || strings.Contains(query, "mode=memory") {
+		return true
+	}
+
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// openConn opens a connection to the database at url using the
+// dialect registered for its scheme.
+func openConn(url string) (*sql.DB, Dialect, error) {
+	d, err := dialectFor(url)
+	if err != nil {
+		return nil, d, err
+	}
+
+	dsn := url
+	if d.DSN != nil {
+		dsn, err = d.DSN(url)
+		if err != nil {
+			return nil, d, err
+		}
+	}
+
+	conn, err := sql.Open(d.Driver, dsn)
+	if err != nil {
+		return nil, d, fmt.Errorf("error opening connection: %w", err)
+	}
+
+	return conn, d, nil
+}
+
+// createDatabase creates the database at url.
+func createDatabase(url string) error {
+	d, err := dialectFor(url)
+	if err != nil {
+		return err
+	}
+
+	if d.Create == nil {
+		return fmt.Errorf("creating %s databases is not supported", d.Driver)
+	}
+
+	return d.Create(url)
+}
+
+// dropDatabase drops the database at url.
+func dropDatabase(url string) error {
+	d, err := dialectFor(url)
+	if err != nil {
+		return err
+	}
+
+	if d.Drop == nil {
+		return fmt.Errorf("dropping %s databases is not supported", d.Driver)
+	}
+
+	return d.Drop(url)
+}
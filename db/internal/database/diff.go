@@ -0,0 +1,652 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// diffMigration is the name of the migration db diff generates from
+// the differences, none when empty
+var diffMigration string
+
+// diffFlags registers the --generate flag on fs.
+func diffFlags(fs *flag.FlagSet) {
+	fs.StringVar(&diffMigration, "generate", "", "generate a migration with this name that brings the migrations in line with the database")
+}
+
+// schemaInfo is the introspected schema of a database by table name.
+type schemaInfo map[string]*tableInfo
+
+// tableInfo is a table in a schemaInfo. Columns map to their
+// definitions and are kept in order, indexes map to the statements
+// creating them and constraints to their definitions.
+type tableInfo struct {
+	columns     map[string]string
+	order       []string
+	indexes     map[string]string
+	constraints map[string]string
+}
+
+// table returns the table with the given name, adding it if needed.
+func (s schemaInfo) table(name string) *tableInfo {
+	if s[name] == nil {
+		s[name] = &tableInfo{
+			columns:     map[string]string{},
+			indexes:     map[string]string{},
+			constraints: map[string]string{},
+		}
+	}
+
+	return s[name]
+}
+
+// addColumn adds a column to the table keeping the column order.
+func (t *tableInfo) addColumn(name, definition string) {
+	t.columns[name] = definition
+	t.order = append(t.order, name)
+}
+
+// inspectors introspect the schema of a database by driver name.
+var inspectors = map[string]func(*sql.DB) (schemaInfo, error){
+	"sqlite3":  inspectSQLite,
+	"postgres": inspectPostgres,
+	"mysql":    inspectMySQL,
+}
+
+// inspectSchema returns the schema of the database at url, leaving
+// out the migrations bookkeeping tables and the indexes backing
+// constraints.
+func inspectSchema(url string) (schemaInfo, error) {
+	conn, d, err := openConn(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	inspect, ok := inspectors[d.Driver]
+	if !ok {
+		return nil, fmt.Errorf("inspecting %s schemas is not supported", d.Driver)
+	}
+
+	schema, err := inspect(conn)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting schema: %w", err)
+	}
+
+	delete(schema, "schema_migrations")
+	delete(schema, "schema_migrations_lock")
+	for _, t := range schema {
+		for name := range t.constraints {
+			delete(t.indexes, name)
+		}
+	}
+
+	return schema, nil
+}
+
+// eachRow runs query and calls fn after scanning each row into dest.
+func eachRow(conn *sql.DB, query string, dest []any, fn func() error) error {
+	rows, err := conn.Query(query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// columnDefinition returns the definition of a column from its
+// introspected type, nullability and default.
+func columnDefinition(sqlType string, notNull bool, dflt sql.NullString) string {
+	definition := strings.ToUpper(sqlType)
+	if notNull {
+		definition += " NOT NULL"
+	}
+
+	if dflt.Valid {
+		definition += " DEFAULT " + dflt.String
+	}
+
+	return definition
+}
+
+// postgresColumn returns the definition of a Postgres column. The
+// nextval default of a column owning its sequence turns back into the
+// serial type that created it, and identity columns get their
+// GENERATED clause, so the definitions can be run on other databases.
+func postgresColumn(sqlType string, notNull bool, dflt sql.NullString, identity string, owned bool) string {
+	serials := map[string]string{"smallint": "smallserial", "integer": "serial", "bigint": "bigserial"}
+	if serial, ok := serials[sqlType]; ok && owned && strings.HasPrefix(dflt.String, "nextval(") {
+		return serial
+	}
+
+	definition := sqlType
+	if notNull {
+		definition += " NOT NULL"
+	}
+
+	if dflt.Valid {
+		definition += " DEFAULT " + dflt.String
+	}
+
+	switch identity {
+	case "a":
+		definition += " GENERATED ALWAYS AS IDENTITY"
+	case "d":
+		definition += " GENERATED BY DEFAULT AS IDENTITY"
+	}
+
+	return definition
+}
+
+// inspectSQLite introspects sqlite_master and the table pragmas.
+// Unnamed constraints are keyed by their definition.
+func inspectSQLite(conn *sql.DB) (schemaInfo, error) {
+	schema := schemaInfo{}
+
+	var name string
+	err := eachRow(conn, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`, []any{&name}, func() error {
+		schema.table(name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, t := range schema {
+		var (
+			column, sqlType string
+			notNull, pk     int
+			dflt            sql.NullString
+			pks             []string
+		)
+
+		err := eachRow(conn, `SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(`+quote(name)+`) ORDER BY cid`, []any{&column, &sqlType, &notNull, &dflt, &pk}, func() error {
+			t.addColumn(column, columnDefinition(sqlType, notNull == 1, dflt))
+			if pk > 0 {
+				pks = append(pks, column)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case len(pks) == 1:
+			t.columns[pks[0]] += " PRIMARY KEY"
+		case len(pks) > 1:
+			definition := fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(pks, ", "))
+			t.constraints[definition] = definition
+		}
+
+		var index, statement string
+		err = eachRow(conn, `SELECT name, sql FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL AND tbl_name = `+quote(name), []any{&index, &statement}, func() error {
+			t.indexes[index] = statement + ";"
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Unique constraints are backed by automatic indexes.
+		var origin string
+		var uniques []string
+		err = eachRow(conn, `SELECT name, origin FROM pragma_index_list(`+quote(name)+`)`, []any{&index, &origin}, func() error {
+			if origin == "u" {
+				uniques = append(uniques, index)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, index := range uniques {
+			var columns []string
+			err := eachRow(conn, `SELECT name FROM pragma_index_info(`+quote(index)+`) ORDER BY seqno`, []any{&column}, func() error {
+				columns = append(columns, column)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			definition := fmt.Sprintf("UNIQUE (%s)", strings.Join(columns, ", "))
+			t.constraints[definition] = definition
+		}
+
+		type foreignKey struct {
+			table    string
+			from, to []string
+		}
+
+		var (
+			id              int
+			table, from, to string
+			fks             = map[int]*foreignKey{}
+		)
+
+		err = eachRow(conn, `SELECT id, "table", "from", "to" FROM pragma_foreign_key_list(`+quote(name)+`) ORDER BY id, seq`, []any{&id, &table, &from, &to}, func() error {
+			if fks[id] == nil {
+				fks[id] = &foreignKey{table: table}
+			}
+
+			fks[id].from = append(fks[id].from, from)
+			fks[id].to = append(fks[id].to, to)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fk := range fks {
+			definition := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s (%s)", strings.Join(fk.from, ", "), fk.table, strings.Join(fk.to, ", "))
+			t.constraints[definition] = definition
+		}
+	}
+
+	return schema, nil
+}
+
+// inspectPostgres introspects the current schema through
+// information_schema and the pg catalogs.
+func inspectPostgres(conn *sql.DB) (schemaInfo, error) {
+	schema := schemaInfo{}
+
+	var table, name, definition, identity string
+	var notNull, owned bool
+	var dflt sql.NullString
+	err := eachRow(conn, `SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'`, []any{&table}, func() error {
+		schema.table(table)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// format_type keeps lengths, arrays and enum names that
+	// information_schema leaves out.
+	err = eachRow(conn, `SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull, pg_get_expr(d.adbin, d.adrelid), a.attidentity,
+			pg_get_serial_sequence(quote_ident(n.nspname) || '.' || quote_ident(c.relname), a.attname) IS NOT NULL
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p') AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY c.relname, a.attnum`, []any{&table, &name, &definition, &notNull, &dflt, &identity, &owned}, func() error {
+		schema.table(table).addColumn(name, postgresColumn(definition, notNull, dflt, identity, owned))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = eachRow(conn, `SELECT tablename, indexname, indexdef FROM pg_indexes WHERE schemaname = current_schema()`, []any{&table, &name, &definition}, func() error {
+		schema.table(table).indexes[name] = definition + ";"
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = eachRow(conn, `SELECT c.relname, con.conname, pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema()`, []any{&table, &name, &definition}, func() error {
+		schema.table(table).constraints[name] = definition
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// inspectMySQL introspects the current database through
+// information_schema.
+func inspectMySQL(conn *sql.DB) (schemaInfo, error) {
+	schema := schemaInfo{}
+
+	var table, name, definition, nullable string
+	var dflt sql.NullString
+	err := eachRow(conn, `SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'`, []any{&table}, func() error {
+		schema.table(table)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = eachRow(conn, `SELECT c.table_name, c.column_name, c.column_type, c.is_nullable, c.column_default
+		FROM information_schema.columns c
+		JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = DATABASE() AND t.table_type = 'BASE TABLE'
+		ORDER BY c.table_name, c.ordinal_position`, []any{&table, &name, &definition, &nullable, &dflt}, func() error {
+		schema.table(table).addColumn(name, columnDefinition(definition, nullable == "NO", dflt))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var unique bool
+	var columns string
+	err = eachRow(conn, `SELECT table_name, index_name, non_unique = 0, GROUP_CONCAT(column_name ORDER BY seq_in_index SEPARATOR ', ')
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE()
+		GROUP BY table_name, index_name, non_unique`, []any{&table, &name, &unique, &columns}, func() error {
+		kind := "INDEX"
+		if unique {
+			kind = "UNIQUE INDEX"
+		}
+
+		schema.table(table).indexes[name] = fmt.Sprintf("CREATE %s %s ON %s (%s);", kind, name, table, columns)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var kind string
+	var refTable, refColumns sql.NullString
+	err = eachRow(conn, `SELECT k.table_name, k.constraint_name, c.constraint_type,
+			GROUP_CONCAT(k.column_name ORDER BY k.ordinal_position SEPARATOR ', '),
+			MAX(k.referenced_table_name),
+			GROUP_CONCAT(k.referenced_column_name ORDER BY k.ordinal_position SEPARATOR ', ')
+		FROM information_schema.key_column_usage k
+		JOIN information_schema.table_constraints c
+			ON c.constraint_schema = k.constraint_schema AND c.constraint_name = k.constraint_name AND c.table_name = k.table_name
+		WHERE k.table_schema = DATABASE()
+		GROUP BY k.table_name, k.constraint_name, c.constraint_type`, []any{&table, &name, &kind, &columns, &refTable, &refColumns}, func() error {
+		definition := fmt.Sprintf("%s (%s)", kind, columns)
+		if refTable.Valid {
+			definition += fmt.Sprintf(" REFERENCES %s (%s)", refTable.String, refColumns.String)
+		}
+
+		schema.table(table).constraints[name] = definition
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// schemaDiff is the difference between the schema the migrations
+// build and the one of the database, with the SQL that brings the
+// migrations in line with the database.
+type schemaDiff struct {
+	lines []string
+	up    []string
+	down  []string
+
+	// manual counts the differences without generated SQL.
+	manual int
+}
+
+// change adds a difference with its up and down SQL.
+func (sd *schemaDiff) change(line, up, down string) {
+	sd.lines = append(sd.lines, line)
+	sd.up = append(sd.up, up)
+	sd.down = append(sd.down, down)
+}
+
+// unsupported adds a difference that needs a migration written by
+// hand. Column type and constraint changes are, SQLite can't alter
+// them in place and elsewhere they may need the data converted.
+func (sd *schemaDiff) unsupported(line string) {
+	sd.lines = append(sd.lines, line)
+	sd.manual++
+}
+
+// diffSchemas compares the schema built by the migrations with the
+// one of the database. Lines starting with + are only in the
+// database, - only in the migrations and ~ differ.
+func diffSchemas(migrations, database schemaInfo, d Dialect) schemaDiff {
+	var sd schemaDiff
+	for _, name := range sortedKeys(mergeKeys(migrations, database)) {
+		from, to := migrations[name], database[name]
+		switch {
+		case from == nil:
+			sd.change("+ table "+name, createTableSQL(name, to), "DROP TABLE "+name+";")
+			continue
+		case to == nil:
+			sd.change("- table "+name, "DROP TABLE "+name+";", createTableSQL(name, from))
+			continue
+		}
+
+		for _, column := range columnOrder(from, to) {
+			before, inMigrations := from.columns[column]
+			after, inDatabase := to.columns[column]
+			add := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", name, column, after)
+			drop := fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", name, column)
+
+			switch {
+			case !inMigrations:
+				sd.change(fmt.Sprintf("+ column %s.%s %s", name, column, after), add, drop)
+			case !inDatabase:
+				sd.change(fmt.Sprintf("- column %s.%s %s", name, column, before), drop, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", name, column, before))
+			case before != after:
+				sd.unsupported(fmt.Sprintf("~ column %s.%s %s -> %s", name, column, before, after))
+			}
+		}
+
+		for _, index := range sortedKeys(mergeKeys(from.indexes, to.indexes)) {
+			before, inMigrations := from.indexes[index]
+			after, inDatabase := to.indexes[index]
+			drop := dropIndex(d, name, index)
+
+			switch {
+			case !inMigrations:
+				sd.change("+ index "+index, after, drop)
+			case !inDatabase:
+				sd.change("- index "+index, drop, before)
+			case before != after:
+				sd.change("~ index "+index, drop+"\n"+after, drop+"\n"+before)
+			}
+		}
+
+		for _, constraint := range sortedKeys(mergeKeys(from.constraints, to.constraints)) {
+			before, inMigrations := from.constraints[constraint]
+			after, inDatabase := to.constraints[constraint]
+
+			switch {
+			case !inMigrations:
+				sd.unsupported(fmt.Sprintf("+ constraint %s %s", name, after))
+			case !inDatabase:
+				sd.unsupported(fmt.Sprintf("- constraint %s %s", name, before))
+			case before != after:
+				sd.unsupported(fmt.Sprintf("~ constraint %s %s -> %s", name, before, after))
+			}
+		}
+	}
+
+	return sd
+}
+
+// mergeKeys returns a map with the keys of both maps.
+func mergeKeys[V any](a, b map[string]V) map[string]bool {
+	keys := map[string]bool{}
+	for key := range a {
+		keys[key] = true
+	}
+
+	for key := range b {
+		keys[key] = true
+	}
+
+	return keys
+}
+
+// columnOrder returns the columns of both tables, the ones of from
+// in their order followed by the ones only in to.
+func columnOrder(from, to *tableInfo) []string {
+	columns := slices.Clone(from.order)
+	for _, column := range to.order {
+		if !slices.Contains(columns, column) {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// createTableSQL returns the statements creating the table with its
+// columns, constraints and indexes.
+func createTableSQL(name string, t *tableInfo) string {
+	var definitions []string
+	for _, column := range t.order {
+		definitions = append(definitions, column+" "+t.columns[column])
+	}
+
+	for _, constraint := range sortedKeys(t.constraints) {
+		definitions = append(definitions, t.constraints[constraint])
+	}
+
+	statements := []string{fmt.Sprintf("CREATE TABLE %s (\n\t%s\n);", name, strings.Join(definitions, ",\n\t"))}
+	for _, index := range sortedKeys(t.indexes) {
+		statements = append(statements, t.indexes[index])
+	}
+
+	return strings.Join(statements, "\n")
+}
+
+// diffDatabase builds a scratch database from the migrations folder
+// and prints how the database at url differs from it. It returns an
+// error when they differ unless a migration is generated from the
+// differences.
+func diffDatabase(url string) error {
+	d, err := dialectFor(url)
+	if err != nil {
+		return err
+	}
+
+	scratchURL, cleanup, err := scratchDatabase(url, d)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	if err := migrateScratch(scratchURL, ""); err != nil {
+		return err
+	}
+
+	migrations, err := inspectSchema(scratchURL)
+	if err != nil {
+		return err
+	}
+
+	database, err := inspectSchema(url)
+	if err != nil {
+		return err
+	}
+
+	sd := diffSchemas(migrations, database, d)
+	if len(sd.lines) == 0 {
+		printf("The database matches %s\n", migrationFolder)
+		return nil
+	}
+
+	printf("--- %s\n+++ %s\n", migrationFolder, redactURL(url))
+	for _, line := range sd.lines {
+		printf("%s\n", line)
+	}
+
+	if diffMigration == "" {
+		return fmt.Errorf("%d difference(s) between %s and the database", len(sd.lines), migrationFolder)
+	}
+
+	if sd.manual > 0 {
+		return fmt.Errorf("%d column type or constraint change(s) can't be generated, write the migration by hand", sd.manual)
+	}
+
+	slices.Reverse(sd.down)
+	return newMigration(diffMigration, false, func(string) (string, string, error) {
+		return strings.Join(sd.up, "\n\n"), strings.Join(sd.down, "\n\n"), nil
+	})
+}
+
+// migrateScratch runs the migrations up to target on the scratch
+// database at url. They are not part of the command results.
+func migrateScratch(url, target string) error {
+	current := report
+	report = nil
+	defer func() { report = current }()
+
+	if err := runMigrations(url, target); err != nil {
+		return fmt.Errorf("error building scratch database: %w", err)
+	}
+
+	return nil
+}
+
+// scratchDatabase creates an empty database next to the one at url,
+// named after it with a random suffix, and returns its URL with the
+// function dropping it. SQLite scratch databases are temporary files.
+func scratchDatabase(url string, d Dialect) (string, func(), error) {
+	if d.Driver == "sqlite3" {
+		dir, err := os.MkdirTemp("", "leapkit-diff")
+		if err != nil {
+			return "", nil, fmt.Errorf("error creating scratch database: %w", err)
+		}
+
+		return filepath.Join(dir, "scratch.db"), func() { os.RemoveAll(dir) }, nil
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := strings.Trim(u.Path, "/")
+	if name == "" {
+		return "", nil, fmt.Errorf("could not derive a scratch database from %s", redactURL(url))
+	}
+
+	// The random suffix keeps concurrent runs, and databases that
+	// happen to share the name, apart.
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", nil, fmt.Errorf("error creating scratch database: %w", err)
+	}
+
+	u.Path = "/" + name + "_diff_" + hex.EncodeToString(suffix)
+	scratchURL := u.String()
+	if snapshotExists(scratchURL, d) {
+		return "", nil, fmt.Errorf("scratch database %s already exists", redactURL(scratchURL))
+	}
+
+	if err := createDatabase(scratchURL); err != nil {
+		return "", nil, fmt.Errorf("error creating scratch database: %w", err)
+	}
+
+	return scratchURL, func() { dropDatabase(scratchURL) }, nil
+}
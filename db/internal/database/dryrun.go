@@ -0,0 +1,126 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	flag "github.com/spf13/pflag"
+)
+
+// dryRun prints what migrate would do instead of doing it
+var dryRun bool
+
+// dryRunFlags registers the --dry-run flag on fs.
+func dryRunFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&dryRun, "dry-run", false, "print the pending migrations and their SQL without running them")
+}
+
+// dryRunMigrations prints the migrations runMigrations would roll
+// back and apply, with their SQL and bookkeeping statements. It only
+// reads from the database.
+func dryRunMigrations(url, target string) error {
+	migrations, err := loadMigrations(migrationFolder)
+	if err != nil {
+		return err
+	}
+
+	var applied []appliedMigration
+	var missing [][2]string
+	var hasTable bool
+
+	m, err := openReader(url)
+	if err != nil && !errors.Is(err, errNoDatabase) {
+		return err
+	}
+
+	if m != nil {
+		defer m.Close()
+
+		hasTable = m.hasTable()
+		if hasTable {
+			missing = m.missingColumns()
+			applied, err = m.applied()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Only read, the baselines are recorded when migrating.
+	applied, _, err = squashedApplied(migrations, applied)
+	if err != nil {
+		return err
+	}
+
+	if err := checkDrift(migrations, applied, allowDrift); err != nil {
+		return err
+	}
+
+	plan, err := planMigrations(migrationFolder, migrations, applied, target)
+	if err != nil {
+		return err
+	}
+
+	if len(plan.up) == 0 && len(plan.down) == 0 {
+		fmt.Println("No pending migrations")
+		return nil
+	}
+
+	if !hasTable {
+		fmt.Printf("%s;\n\n", migrationsTableSQL)
+	}
+
+	// Tables created by older versions get the missing columns first.
+	for _, column := range missing {
+		fmt.Printf("%s;\n\n", addColumnSQL(column))
+	}
+
+	for _, mig := range plan.down {
+		body := mig.Down
+		if mig.DownFunc != nil {
+			body = "-- Go migration, runs its registered down function"
+		}
+
+		printDryRun(mig, "down", body, fmt.Sprintf("DELETE FROM schema_migrations WHERE timestamp = %s;", quote(mig.Timestamp)))
+	}
+
+	for _, mig := range plan.up {
+		body := mig.Up
+		if mig.UpFunc != nil {
+			body = "-- Go migration, runs its registered up function"
+		}
+
+		checksum := "NULL"
+		if mig.Checksum != "" {
+			checksum = quote(mig.Checksum)
+		}
+
+		printDryRun(mig, "up", body, fmt.Sprintf(
+			"INSERT INTO schema_migrations (timestamp, name, applied_at, checksum) VALUES (%s, %s, CURRENT_TIMESTAMP, %s);",
+			quote(mig.Timestamp), quote(mig.Name), checksum,
+		))
+	}
+
+	return nil
+}
+
+// printDryRun prints a migration step, wrapped in the transaction
+// it would run in.
+func printDryRun(mig migration, direction, body, bookkeeping string) {
+	fmt.Printf("-- %s_%s (%s)\n", mig.Timestamp, mig.Name, direction)
+
+	if !mig.NoTransaction {
+		fmt.Println("BEGIN;")
+	}
+
+	if body != "" {
+		fmt.Println(body)
+	}
+
+	fmt.Println(bookkeeping)
+	if !mig.NoTransaction {
+		fmt.Println("COMMIT;")
+	}
+
+	fmt.Println()
+}
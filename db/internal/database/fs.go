@@ -0,0 +1,40 @@
+package database
+
+import "io/fs"
+
+// fsSource names migrations loaded from an fs.FS in errors.
+const fsSource = "the migrations fs.FS"
+
+// MigrateFS applies the migrations in fsys and the registered Go
+// migrations to the database at url, holding the migrations lock.
+// When target is set it migrates up or down to that timestamp.
+func MigrateFS(url string, fsys fs.FS, target string, opts Options) error {
+	migrations, err := loadMigrationsFS(fsys)
+	if err != nil {
+		return err
+	}
+
+	return applyMigrations(url, fsSource, migrations, target, opts)
+}
+
+// RollbackFS reverts the last steps applied migrations in the
+// database at url, taking their down SQL from fsys.
+func RollbackFS(url string, fsys fs.FS, steps int, opts Options) error {
+	migrations, err := loadMigrationsFS(fsys)
+	if err != nil {
+		return err
+	}
+
+	return revertSteps(url, fsSource, migrations, steps, opts)
+}
+
+// StatusFS returns the status of the migrations in fsys in the
+// database at url, see MigrationStatus.
+func StatusFS(url string, fsys fs.FS) ([]MigrationStatus, error) {
+	migrations, err := loadMigrationsFS(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrationStatuses(url, migrations)
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// createTableExp matches names like create_users or create_users_table.
+	createTableExp = regexp.MustCompile(`^create_(\w+?)(_table)?$`)
+
+	// addColumnsExp matches names like add_age_to_users.
+	addColumnsExp = regexp.MustCompile(`^add_\w+_to_(\w+)$`)
+
+	// identifierExp matches the table and column names generators accept.
+	identifierExp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	// standardTypes are the column types for dialects without Types.
+	standardTypes = map[string]string{
+		"string":    "VARCHAR(255)",
+		"text":      "TEXT",
+		"integer":   "INTEGER",
+		"bigint":    "BIGINT",
+		"float":     "DOUBLE PRECISION",
+		"decimal":   "NUMERIC",
+		"boolean":   "BOOLEAN",
+		"date":      "DATE",
+		"time":      "TIME",
+		"datetime":  "TIMESTAMP",
+		"timestamp": "TIMESTAMP",
+		"uuid":      "UUID",
+		"json":      "JSON",
+		"binary":    "BLOB",
+	}
+)
+
+// column is a column argument such as email:string:unique.
+type column struct {
+	Name     string
+	Type     string
+	Unique   bool
+	Index    bool
+	Required bool
+}
+
+// parseColumns parses name:type[:modifier...] arguments, where the
+// modifiers are unique, index and required.
+func parseColumns(args []string, d Dialect) ([]column, error) {
+	types := d.Types
+	if len(types) == 0 {
+		types = standardTypes
+	}
+
+	columns := make([]column, 0, len(args))
+	for _, arg := range args {
+		parts := strings.Split(arg, ":")
+		if len(parts) < 2 || !identifierExp.MatchString(parts[0]) {
+			return nil, fmt.Errorf("invalid column %q, expected name:type[:unique|:index|:required]", arg)
+		}
+
+		sqlType, ok := types[strings.ToLower(parts[1])]
+		if !ok {
+			names := make([]string, 0, len(types))
+			for name := range types {
+				names = append(names, name)
+			}
+
+			slices.Sort(names)
+			return nil, fmt.Errorf("unknown column type %q in %q, supported types are %s", parts[1], arg, strings.Join(names, ", "))
+		}
+
+		c := column{Name: parts[0], Type: sqlType}
+		for _, modifier := range parts[2:] {
+			switch strings.ToLower(modifier) {
+			case "unique":
+				c.Unique = true
+			case "index":
+				c.Index = true
+			case "required":
+				c.Required = true
+			default:
+				return nil, fmt.Errorf("unknown column modifier %q in %q", modifier, arg)
+			}
+		}
+
+		columns = append(columns, c)
+	}
+
+	return columns, nil
+}
+
+// inferMigration builds the up and down SQL for migrations named like
+// create_users_table or add_age_to_users from their column arguments.
+// Other names without columns generate an empty migration.
+func inferMigration(name string, args []string, d Dialect) (up, down string, err error) {
+	columns, err := parseColumns(args, d)
+	if err != nil {
+		return "", "", err
+	}
+
+	if match := createTableExp.FindStringSubmatch(name); match != nil {
+		up, down = createTable(match[1], columns, d)
+		return up, down, nil
+	}
+
+	if match := addColumnsExp.FindStringSubmatch(name); match != nil && len(columns) > 0 {
+		up, down = addColumns(match[1], columns, d)
+		for _, c := range columns {
+			if c.Required {
+				printf("📝 %s.%s is added without NOT NULL, existing rows need a value first, see the migration\n", match[1], c.Name)
+			}
+		}
+
+		return up, down, nil
+	}
+
+	if len(columns) > 0 {
+		return "", "", fmt.Errorf("can't infer a migration for %s, columns are supported for create_<table> and add_<columns>_to_<table> migrations", name)
+	}
+
+	return "", "", nil
+}
+
+// createTable returns the SQL to create and drop table.
+func createTable(table string, columns []column, d Dialect) (up, down string) {
+	definitions := []string{cmp.Or(d.PrimaryKey, "id INTEGER PRIMARY KEY")}
+	for _, c := range columns {
+		definitions = append(definitions, c.definition())
+	}
+
+	up = fmt.Sprintf("CREATE TABLE %s (\n\t%s\n);", table, strings.Join(definitions, ",\n\t"))
+	if indexes := createIndexes(table, columns); indexes != "" {
+		up += "\n\n" + indexes
+	}
+
+	return up, fmt.Sprintf("DROP TABLE %s;", table)
+}
+
+// addColumns returns the SQL to add columns to table and to remove them.
+// Required columns are added nullable, since the rows already in table
+// have no value for them and SQLite refuses NOT NULL columns without a
+// default. A comment in the SQL says how to finish them.
+func addColumns(table string, columns []column, d Dialect) (up, down string) {
+	var ups, downs []string
+	for _, c := range columns {
+		if !c.Required {
+			ups = append(ups, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", table, c.definition()))
+			continue
+		}
+
+		c.Required = false
+		ups = append(ups,
+			fmt.Sprintf("-- %s is required: fill it in for the existing rows and make it NOT NULL, or give it a DEFAULT and NOT NULL here.", c.Name),
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", table, c.definition()),
+		)
+	}
+
+	if indexes := createIndexes(table, columns); indexes != "" {
+		ups = append(ups, "", indexes)
+	}
+
+	for _, c := range slices.Backward(columns) {
+		if c.Unique || c.Index {
+			downs = append(downs, dropIndex(d, table, indexName(table, c)))
+		}
+
+		downs = append(downs, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, c.Name))
+	}
+
+	return strings.Join(ups, "\n"), strings.Join(downs, "\n")
+}
+
+// createIndexes returns the CREATE INDEX statements for the columns
+// marked as unique or index.
+func createIndexes(table string, columns []column) string {
+	var statements []string
+	for _, c := range columns {
+		switch {
+		case c.Unique:
+			statements = append(statements, fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s);", indexName(table, c), table, c.Name))
+		case c.Index:
+			statements = append(statements, fmt.Sprintf("CREATE INDEX %s ON %s (%s);", indexName(table, c), table, c.Name))
+		}
+	}
+
+	return strings.Join(statements, "\n")
+}
+
+// dropIndex returns the statement dropping index from table.
+func dropIndex(d Dialect, table, index string) string {
+	if d.DropIndex != nil {
+		return d.DropIndex(table, index)
+	}
+
+	return fmt.Sprintf("DROP INDEX %s;", index)
+}
+
+// indexName returns the name of the index for the column.
+func indexName(table string, c column) string {
+	if c.Unique {
+		return fmt.Sprintf("%s_%s_key", table, c.Name)
+	}
+
+	return fmt.Sprintf("%s_%s_index", table, c.Name)
+}
+
+// definition returns the column definition for CREATE and ALTER TABLE.
+func (c column) definition() string {
+	if c.Required {
+		return fmt.Sprintf("%s %s NOT NULL", c.Name, c.Type)
+	}
+
+	return fmt.Sprintf("%s %s", c.Name, c.Type)
+}
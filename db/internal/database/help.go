@@ -0,0 +1,127 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	flag "github.com/spf13/pflag"
+)
+
+// command describes a db command for dispatching and help.
+type command struct {
+	name string
+
+	// args are the positional arguments in the usage line, minArgs
+	// how many are required and variadic whether more are accepted.
+	args     string
+	minArgs  int
+	variadic bool
+
+	summary string
+	flags   []string
+}
+
+// globalFlags are the flags every command accepts.
+var globalFlags = []string{"db", "format"}
+
+// commands are the db commands in the order help lists them.
+var commands = []command{
+	{name: "create", summary: "Create the database", flags: []string{"wait", "timeout"}},
+	{name: "drop", summary: "Drop the database"},
+	{name: "migrate", summary: "Apply the pending migrations, or migrate up or down to --to", flags: []string{"migration.folder", "to", "dry-run", "allow-drift", "lock-timeout", "wait", "timeout"}},
+	{name: "rollback", summary: "Roll back the most recent migrations", flags: []string{"migration.folder", "steps", "lock-timeout"}},
+	{name: "status", summary: "List the migrations and whether they were applied, failing when some are pending", flags: []string{"migration.folder"}},
+	{name: "reset", summary: "Drop and create the database, then load the schema file and apply the migrations", flags: []string{"migration.folder", "seed", "seed.folder", "allow-drift", "lock-timeout", "wait", "timeout"}},
+	{name: "verify", summary: "Report applied migrations that changed, are missing or are unknown", flags: []string{"migration.folder"}},
+	{name: "seed", summary: "Run the seed files and registered seed functions", flags: []string{"seed.folder"}},
+	{name: "test:prepare", summary: "Drop, create and migrate the test database", flags: []string{"migration.folder", "allow-drift", "lock-timeout"}},
+	{name: "schema:dump", summary: "Write the database schema to schema.sql next to the migrations folder", flags: []string{"migration.folder"}},
+	{name: "schema:load", summary: "Load schema.sql into the database", flags: []string{"migration.folder"}},
+	{name: "squash", summary: "Replace the migrations before --before with a baseline migration holding their schema", flags: []string{"migration.folder", "before", "lock-timeout"}},
+	{name: "diff", summary: "Compare the schema the migrations build with the database, optionally generating a migration from the differences", flags: []string{"migration.folder", "generate"}},
+	{name: "snapshot", args: "<save|restore> <name>", minArgs: 2, summary: "Save the database as a named snapshot, or restore it from one", flags: []string{"anonymize"}},
+	{name: "wait", summary: "Wait until the database accepts connections, up to --timeout", flags: []string{"timeout"}},
+	{name: "console", summary: "Open an interactive SQL shell on the database"},
+	{name: "generate_migration", args: "<name> [column:type[:modifier]...]", minArgs: 1, variadic: true, summary: "Generate a migration, inferring create_<table> and add_<columns>_to_<table> ones from the name", flags: []string{"migration.folder", "go", "migration.template"}},
+}
+
+// findCommand returns the command with the given name.
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+
+	return command{}, false
+}
+
+// usage returns the usage line of the command.
+func (c command) usage() string {
+	usage := c.name + " [flags]"
+
+	if c.args != "" {
+		usage += " " + c.args
+	}
+
+	return usage
+}
+
+// checkArgs returns an error when args are not the arguments the
+// command takes.
+func (c command) checkArgs(args []string) error {
+	if len(args) < c.minArgs {
+		return fmt.Errorf("missing arguments, usage: db %s", c.usage())
+	}
+
+	if !c.variadic && len(args) > c.minArgs {
+		return fmt.Errorf("unexpected arguments %s, usage: db %s", strings.Join(args[c.minArgs:], " "), c.usage())
+	}
+
+	return nil
+}
+
+// help prints the list of commands, or the help of the command in
+// args.
+func help(args []string) error {
+	if len(args) == 0 {
+		printHelp(os.Stdout)
+		return nil
+	}
+
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		return fmt.Errorf("unknown command %q, run `db help` to list the commands", args[0])
+	}
+
+	fmt.Printf("Usage: db %s\n\n%s.\n\nFlags:\n", cmd.usage(), cmd.summary)
+
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	for _, name := range slices.Concat(cmd.flags, globalFlags) {
+		if f := commandLine.Lookup(name); f != nil {
+			flags.AddFlag(f)
+		}
+	}
+
+	fmt.Print(flags.FlagUsages())
+	return nil
+}
+
+// printHelp prints the commands with their summaries.
+func printHelp(w io.Writer) {
+	fmt.Fprint(w, "Usage: db <command> [flags] [arguments]\n\nCommands:\n")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+
+	fmt.Fprintf(tw, "  help\t%s\n", "Show the help of a command")
+	tw.Flush()
+
+	fmt.Fprint(w, "\nRun `db help <command>` for the flags of a command.\n")
+}
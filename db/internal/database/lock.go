@@ -0,0 +1,214 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	flag "github.com/spf13/pflag"
+)
+
+// lockTimeout is how long to wait for another migration to finish
+var lockTimeout time.Duration
+
+// lockFlags registers the --lock-timeout flag on fs.
+func lockFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&lockTimeout, "lock-timeout", 30*time.Second, "how long to wait for another migration run to release the migrations lock")
+}
+
+// migrationsLockID identifies the migrations lock in Postgres
+// advisory locks, which are per database.
+const migrationsLockID = 7_152_308_211_734_290
+
+// acquireLock takes the migrations lock with the dialect's Lock, or
+// with the schema_migrations_lock table when the dialect has none. It
+// waits up to timeout for other runners to release it.
+func acquireLock(conn *sql.DB, d Dialect, timeout time.Duration) (func() error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	lock := d.Lock
+	if lock == nil {
+		lock = tableLock(d)
+	}
+
+	unlock, err := lock(ctx, conn)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("timed out after %s waiting for the migrations lock, another migration is probably running", timeout)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error acquiring migrations lock: %w", err)
+	}
+
+	return unlock, nil
+}
+
+// retryLock calls try with backoff until it takes the lock, fails,
+// or ctx is done.
+func retryLock(ctx context.Context, try func() (bool, error)) error {
+	delay := 50 * time.Millisecond
+	for {
+		ok, err := try()
+		if err != nil || ok {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, time.Second)
+	}
+}
+
+// staleLockAge is how long the row in schema_migrations_lock can go
+// without being refreshed before it is taken for the lock of a runner
+// that crashed. Runners holding it refresh it every staleLockAge/6.
+const staleLockAge = time.Minute
+
+// tableLock locks by inserting the single row of the
+// schema_migrations_lock table. It works on any database, and is
+// what SQLite uses.
+func tableLock(d Dialect) func(context.Context, *sql.DB) (func() error, error) {
+	return func(ctx context.Context, conn *sql.DB) (func() error, error) {
+		_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations_lock (id INTEGER NOT NULL PRIMARY KEY, locked_at TIMESTAMP)`)
+		if err != nil {
+			return nil, err
+		}
+
+		err = retryLock(ctx, func() (bool, error) {
+			_, err := conn.ExecContext(ctx, d.rebind(`INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, $1)`), time.Now().UTC())
+			if err == nil {
+				return true, nil
+			}
+
+			// The insert conflicts while another runner holds the
+			// row and SQLite is busy while another connection writes,
+			// any other failure is returned.
+			if databaseBusy(err) {
+				return false, nil
+			}
+
+			var lockedAt sql.NullTime
+			serr := conn.QueryRowContext(ctx, `SELECT locked_at FROM schema_migrations_lock WHERE id = 1`).Scan(&lockedAt)
+			if errors.Is(serr, sql.ErrNoRows) {
+				return false, err
+			}
+
+			if databaseBusy(serr) {
+				return false, nil
+			}
+
+			if serr != nil {
+				return false, serr
+			}
+
+			if lockedAt.Valid && time.Since(lockedAt.Time) > staleLockAge {
+				cutoff := time.Now().UTC().Add(-staleLockAge)
+				if _, err := conn.ExecContext(ctx, d.rebind(`DELETE FROM schema_migrations_lock WHERE id = 1 AND locked_at < $1`), cutoff); err != nil {
+					return false, err
+				}
+			}
+
+			return false, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		done := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(staleLockAge / 6)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					// A failed refresh is retried on the next tick.
+					conn.Exec(d.rebind(`UPDATE schema_migrations_lock SET locked_at = $1 WHERE id = 1`), time.Now().UTC())
+				}
+			}
+		}()
+
+		return func() error {
+			close(done)
+
+			_, err := conn.Exec(`DELETE FROM schema_migrations_lock WHERE id = 1`)
+			return err
+		}, nil
+	}
+}
+
+// databaseBusy reports whether err is SQLite saying another
+// connection holds a lock on the database.
+func databaseBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// postgresLock takes a session advisory lock on a dedicated connection.
+func postgresLock(ctx context.Context, conn *sql.DB) (func() error, error) {
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = retryLock(ctx, func() (bool, error) {
+		var ok bool
+		err := c.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, migrationsLockID).Scan(&ok)
+		return ok, err
+	})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return func() error {
+		defer c.Close()
+
+		_, err := c.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationsLockID)
+		return err
+	}, nil
+}
+
+// mysqlLock takes a named lock on a dedicated connection.
+func mysqlLock(ctx context.Context, conn *sql.DB) (func() error, error) {
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Named locks are server wide and at most 64 characters long, so
+	// the name ends with a hash of the database name.
+	var name string
+	err = c.QueryRowContext(ctx, `SELECT CONCAT('leapkit_migrations_', SHA1(COALESCE(DATABASE(), '')))`).Scan(&name)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	err = retryLock(ctx, func() (bool, error) {
+		var ok sql.NullInt64
+		err := c.QueryRowContext(ctx, `SELECT GET_LOCK(?, 0)`, name).Scan(&ok)
+		return ok.Int64 == 1, err
+	})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return func() error {
+		defer c.Close()
+
+		_, err := c.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, name)
+		return err
+	}, nil
+}
@@ -1,18 +1,20 @@
 package database
 
 import (
-	"database/sql"
 	_ "embed"
 	"fmt"
-	"html/template"
+	"go/token"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"text/template"
 	"time"
+	"unicode"
 
 	flag "github.com/spf13/pflag"
-
-	"go.leapkit.dev/core/db"
 )
 
 var (
@@ -20,24 +22,92 @@ var (
 	//go:embed migration.sql.tmpl
 	migrationTemplate string
 
+	// goMigrationTemplate is the template for generating Go migrations
+	//go:embed migration.go.tmpl
+	goMigrationTemplate string
+
 	// migrationFolder is the folder where the migrations are stored
 	migrationFolder string
+
+	// rollbackSteps is the number of migrations to roll back
+	rollbackSteps int
+
+	// migrationTarget is the timestamp of the migration to migrate to
+	migrationTarget string
+
+	// goMigration generates a Go migration instead of a SQL one
+	goMigration bool
+
+	// migrationTemplateFile is a custom template for new migrations
+	migrationTemplateFile string
 )
 
-func init() {
-	flag.StringVar(&migrationFolder, "migration.folder", filepath.Join("internal", "migrations"), "the folder where the migrations are stored")
+// migrationFlags registers the migration flags on fs.
+func migrationFlags(fs *flag.FlagSet) {
+	fs.StringVar(&migrationFolder, "migration.folder", filepath.Join("internal", "migrations"), "the folder where the migrations are stored")
+	fs.IntVar(&rollbackSteps, "steps", 1, "the number of migrations to roll back")
+	fs.StringVar(&migrationTarget, "to", "", "the timestamp of the migration to migrate up or down to")
+	fs.BoolVar(&goMigration, "go", false, "generate a Go migration instead of a SQL one")
+	fs.StringVar(&migrationTemplateFile, "migration.template", "", "the template for new migrations, defaults to .leapkit/migration.sql.tmpl (.go.tmpl with --go) when present")
 }
 
-// newMigration generator function
-func newMigration(name string) error {
-	timestamp := time.Now().Format("20060102150405")
+// newMigration generator function. When goCode is set it
+// generates a Go migration registered with migrate.Register,
+// otherwise content returns the up and down SQL for the normalized
+// name, see inferMigration.
+func newMigration(name string, goCode bool, content func(name string) (up, down string, err error)) error {
+	name = snakeCase(name)
+	if name == "" {
+		return fmt.Errorf("invalid migration name, use letters, digits and underscores")
+	}
+
+	existing, err := migrationFiles(migrationFolder)
+	if err != nil {
+		return err
+	}
+
+	// Two generations in the same second would share the timestamp,
+	// so the next free second is used instead.
+	now := time.Now()
+	timestamp := now.Format("20060102150405")
+	for _, file := range existing {
+		if file.name == name {
+			return fmt.Errorf("migration %s already exists in %s", name, file.path)
+		}
+	}
+
+	for slices.ContainsFunc(existing, func(f migrationFile) bool { return f.timestamp == timestamp }) {
+		now = now.Add(time.Second)
+		timestamp = now.Format("20060102150405")
+	}
+
+	ext := "sql"
+	if goCode {
+		ext = "go"
+	}
+
+	tmpl, err := templateFor(goCode)
+	if err != nil {
+		return err
+	}
+
+	// Go migrations are scaffolded empty, whatever the name infers.
+	var up, down string
+	if !goCode {
+		up, down, err = content(name)
+		if err != nil {
+			return err
+		}
+	}
+
 	fileName := fmt.Sprintf(
-		"%s_%s.sql",
+		"%s_%s.%s",
 		timestamp,
 		name,
+		ext,
 	)
 
-	t, err := template.New("migration").Parse(migrationTemplate)
+	t, err := template.New("migration").Parse(tmpl)
 	if err != nil {
 		return fmt.Errorf("error parsing migrations template: %w", err)
 	}
@@ -49,37 +119,314 @@ func newMigration(name string) error {
 		return fmt.Errorf("error creating migrations folder: %w", err)
 	}
 
-	f, err := os.Create(fileName)
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
 	if err != nil {
 		return fmt.Errorf("error creating migration file: %w", err)
 	}
 
+	defer f.Close()
+
 	err = t.ExecuteTemplate(f, "migration", map[string]string{
 		"Name":      name,
 		"Timestamp": timestamp,
+		"Package":   packageName(migrationFolder),
+		"Up":        up,
+		"Down":      down,
 	})
 	if err != nil {
 		return fmt.Errorf("error executing migrations template: %w", err)
 	}
 
-	fmt.Printf("✅ Migration file `%v` generated\n", name)
+	printf("✅ Migration file `%v` generated\n", name)
+	return nil
+}
+
+// migrationFileExp matches the SQL and Go files in a migrations folder.
+var migrationFileExp = regexp.MustCompile(`^(\d{14})_(.+?)(\.down)?\.(sql|go)$`)
+
+// migrationFile is a file in the migrations folder.
+type migrationFile struct {
+	timestamp string
+	name      string
+	path      string
+}
+
+// migrationFiles lists the migration files in dir and its subfolders,
+// like loadMigrations reads them. dir may not exist yet.
+func migrationFiles(dir string) ([]migrationFile, error) {
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var files []migrationFile
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error reading migrations folder: %w", err)
+		}
+
+		match := migrationFileExp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		files = append(files, migrationFile{
+			timestamp: match[1],
+			name:      match[2],
+			path:      path,
+		})
+
+		return nil
+	})
+
+	return files, err
+}
+
+// snakeCase normalizes a migration name such as "AddAgeTo users" or
+// "create/users" to add_age_to_users and create_users.
+func snakeCase(name string) string {
+	var b strings.Builder
+	separate := false
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsUpper(r):
+			// Split camel case words, keeping acronyms such as ID together.
+			lowerNext := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) || lowerNext) {
+				separate = true
+			}
+
+			fallthrough
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if separate && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+
+			b.WriteRune(unicode.ToLower(r))
+			separate = false
+		default:
+			separate = true
+		}
+	}
+
+	return b.String()
+}
+
+// templateFor returns the template for new migrations: the one in
+// --migration.template, the project one in .leapkit or the embedded
+// one, in that order.
+func templateFor(goCode bool) (string, error) {
+	path, embedded := filepath.Join(".leapkit", "migration.sql.tmpl"), migrationTemplate
+	if goCode {
+		path, embedded = filepath.Join(".leapkit", "migration.go.tmpl"), goMigrationTemplate
+	}
+
+	if migrationTemplateFile != "" {
+		path = migrationTemplateFile
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) && migrationTemplateFile == "" {
+		return embedded, nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error reading migrations template: %w", err)
+	}
+
+	return string(content), nil
+}
+
+// packageName returns the Go package name for the files in dir,
+// falling back to migrations when its name is not an identifier.
+func packageName(dir string) string {
+	name := strings.ToLower(strings.ReplaceAll(filepath.Base(dir), "-", "_"))
+	if !token.IsIdentifier(name) {
+		return "migrations"
+	}
+
+	return name
+}
+
+// runMigrations applies the pending migrations in the migrations
+// folder. When target is set it only applies the migrations up to
+// target and rolls back the applied ones after it.
+func runMigrations(url, target string) error {
+	migrations, err := loadMigrations(migrationFolder)
+	if err != nil {
+		return err
+	}
+
+	return applyMigrations(url, migrationFolder, migrations, target, flagOptions())
+}
+
+// applyMigrations applies the pending migrations to the database at
+// url, or migrates up or down to target when set. source names where
+// the migrations come from in errors.
+func applyMigrations(url, source string, migrations []migration, target string, opts Options) error {
+	m, err := openMigrator(url, opts)
+	if err != nil {
+		return err
+	}
+
+	defer m.Close()
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	applied, err = m.replaceSquashed(migrations, applied)
+	if err != nil {
+		return err
+	}
+
+	if err := checkDrift(migrations, applied, opts.AllowDrift); err != nil {
+		return err
+	}
+
+	if err := m.updateChecksums(migrations, applied); err != nil {
+		return err
+	}
+
+	plan, err := planMigrations(source, migrations, applied, target)
+	if err != nil {
+		return err
+	}
+
+	if err := revertMigrations(m, plan.down); err != nil {
+		return err
+	}
+
+	for _, mig := range plan.up {
+		if err := m.up(mig); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func runMigrations(url string) error {
-	driver := "sqlite3"
-	if strings.HasPrefix(url, "postgres") {
-		driver = "postgres"
+// migrationPlan is what migrating does: the migrations to roll back,
+// most recent first, and then the ones to apply in order.
+type migrationPlan struct {
+	down []migration
+	up   []migration
+}
+
+// planMigrations works out the plan to migrate to target, or to the
+// latest migration when target is empty.
+func planMigrations(source string, migrations []migration, applied []appliedMigration, target string) (migrationPlan, error) {
+	var plan migrationPlan
+	if target != "" {
+		if !slices.ContainsFunc(migrations, func(mig migration) bool { return mig.Timestamp == target }) {
+			return plan, fmt.Errorf("migration %s not found in %s", target, source)
+		}
+
+		var after []appliedMigration
+		for _, am := range slices.Backward(applied) {
+			if am.Timestamp > target {
+				after = append(after, am)
+			}
+		}
+
+		var err error
+		plan.down, err = reversibleMigrations(source, migrations, after)
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	for _, mig := range migrations {
+		if target != "" && mig.Timestamp > target {
+			break
+		}
+
+		if !isApplied(applied, mig.Timestamp) {
+			plan.up = append(plan.up, mig)
+		}
+	}
+
+	return plan, nil
+}
+
+// rollbackMigrations reverts the last steps applied migrations,
+// most recent first.
+func rollbackMigrations(url string, steps int) error {
+	migrations, err := loadMigrations(migrationFolder)
+	if err != nil {
+		return err
+	}
+
+	return revertSteps(url, migrationFolder, migrations, steps, flagOptions())
+}
+
+// revertSteps reverts the last steps applied migrations in the
+// database at url, most recent first. source names where the
+// migrations come from in errors.
+func revertSteps(url, source string, migrations []migration, steps int, opts Options) error {
+	if steps < 1 {
+		return fmt.Errorf("steps must be greater than 0, got %d", steps)
+	}
+
+	m, err := openMigrator(url, opts)
+	if err != nil {
+		return err
+	}
+
+	defer m.Close()
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
 	}
 
-	conn, err := sql.Open(driver, url)
+	applied, err = m.replaceSquashed(migrations, applied)
 	if err != nil {
-		return fmt.Errorf("error opening connection: %w", err)
+		return err
 	}
 
-	err = db.RunMigrationsDir(migrationFolder, conn)
+	slices.Reverse(applied)
+	toRevert, err := reversibleMigrations(source, migrations, applied[:min(steps, len(applied))])
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return err
+	}
+
+	return revertMigrations(m, toRevert)
+}
+
+// reversibleMigrations returns the migrations for the given applied
+// ones. It fails if one of them is not among the migrations from source
+// or has no down migration, so nothing is reverted partially.
+func reversibleMigrations(source string, migrations []migration, applied []appliedMigration) ([]migration, error) {
+	toRevert := make([]migration, 0, len(applied))
+	for _, am := range applied {
+		i := slices.IndexFunc(migrations, func(mig migration) bool {
+			return mig.Timestamp == am.Timestamp
+		})
+
+		if i == -1 {
+			return nil, fmt.Errorf("applied migration %s not found in %s", am.Timestamp, source)
+		}
+
+		if !migrations[i].reversible() {
+			return nil, fmt.Errorf("migration %s_%s has no down migration", am.Timestamp, migrations[i].Name)
+		}
+
+		toRevert = append(toRevert, migrations[i])
+	}
+
+	return toRevert, nil
+}
+
+// revertMigrations rolls back the migrations in order.
+func revertMigrations(m *migrator, migrations []migration) error {
+	for _, mig := range migrations {
+		if err := m.down(mig); err != nil {
+			return err
+		}
+
+		m.logf("⏪ Rolled back %s_%s\n", mig.Timestamp, mig.Name)
 	}
 
 	return nil
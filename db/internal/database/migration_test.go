@@ -3,7 +3,6 @@ package database_test
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -78,26 +77,307 @@ func TestGenerateMigration(t *testing.T) {
 	})
 
 	t.Run("correct incomplete command", func(t *testing.T) {
-		current := os.Stdout
-		r, w, _ := os.Pipe()
-		os.Stdout = w
-
-		defer func() {
-			os.Stdout = current
-		}()
-
 		os.Args = []string{"db", "generate_migration"}
 		err := database.Exec()
+		if err == nil {
+			t.Fatal("expected missing arguments error, got nil")
+		}
+
+		if !strings.Contains(err.Error(), "usage: db generate_migration [flags] <name>") {
+			t.Errorf("Expected 'usage: db generate_migration [flags] <name>', got: %v", err)
+		}
+	})
+}
+
+func TestGenerateGoMigration(t *testing.T) {
+	bd, _ := os.Getwd()
+	defer os.Chdir(bd)
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("error changing directory: %v", err)
+	}
+
+	_, err := runExec(t, "generate_migration", "backfill_users", "--go", "--migration.folder=internal/app-migrations")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	files, _ := filepath.Glob("internal/app-migrations/*_backfill_users.go")
+	if len(files) != 1 {
+		t.Fatalf("expected one Go migration, got %v", files)
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("error reading migration: %v", err)
+	}
+
+	for _, expected := range []string{"package app_migrations\n", `migrate.Register("`, "func up"} {
+		if !bytes.Contains(content, []byte(expected)) {
+			t.Fatalf("expected migration to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	t.Run("create style name", func(t *testing.T) {
+		// Names that infer SQL still get an empty Go migration.
+		_, err := runExec(t, "generate_migration", "create_users_table", "--go", "--migration.folder=internal/app-migrations")
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		files, _ := filepath.Glob("internal/app-migrations/*_create_users_table.go")
+		if len(files) != 1 {
+			t.Fatalf("expected one Go migration, got %v", files)
+		}
+
+		content, _ := os.ReadFile(files[0])
+		if bytes.Contains(content, []byte("CREATE TABLE")) {
+			t.Fatalf("expected no SQL in the Go migration, got:\n%s", content)
+		}
+	})
+}
+
+func TestMigrationTemplates(t *testing.T) {
+	bd, _ := os.Getwd()
+	defer os.Chdir(bd)
+
+	generated := func(t *testing.T) string {
+		t.Helper()
+
+		files, _ := filepath.Glob("migrations/*_create_users.sql")
+		if len(files) != 1 {
+			t.Fatalf("expected one migration, got %v", files)
+		}
+
+		content, err := os.ReadFile(files[0])
+		if err != nil {
+			t.Fatalf("error reading migration: %v", err)
+		}
+
+		return string(content)
+	}
+
+	t.Run("embedded template", func(t *testing.T) {
+		if err := os.Chdir(t.TempDir()); err != nil {
+			t.Fatalf("error changing directory: %v", err)
+		}
+
+		if _, err := runExec(t, "generate_migration", "create_users", "--migration.folder=migrations"); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		content := generated(t)
+		if !strings.Contains(content, "create_users migration\n-- +up\n") || !strings.Contains(content, "\n-- +down\n") {
+			t.Fatalf("expected up and down sections, got:\n%s", content)
+		}
+	})
+
+	t.Run("project template", func(t *testing.T) {
+		if err := os.Chdir(t.TempDir()); err != nil {
+			t.Fatalf("error changing directory: %v", err)
+		}
+
+		writeMigration(t, ".leapkit", "migration.sql.tmpl", "-- project {{.Name}}\n-- +up\nSET search_path TO app;\n")
+		if _, err := runExec(t, "generate_migration", "create_users", "--migration.folder=migrations"); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if content := generated(t); content != "-- project create_users\n-- +up\nSET search_path TO app;\n" {
+			t.Fatalf("expected project template, got:\n%s", content)
+		}
+	})
+
+	t.Run("template flag", func(t *testing.T) {
+		if err := os.Chdir(t.TempDir()); err != nil {
+			t.Fatalf("error changing directory: %v", err)
+		}
+
+		writeMigration(t, ".leapkit", "migration.sql.tmpl", "-- project\n")
+		writeMigration(t, "templates", "custom.tmpl", "-- custom {{.Timestamp}}\n")
+		if _, err := runExec(t, "generate_migration", "create_users", "--migration.folder=migrations", "--migration.template=templates/custom.tmpl"); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if content := generated(t); !strings.HasPrefix(content, "-- custom 2") {
+			t.Fatalf("expected custom template, got:\n%s", content)
+		}
+
+		_, err := runExec(t, "generate_migration", "create_posts", "--migration.folder=migrations", "--migration.template=missing.tmpl")
+		if err == nil || !strings.Contains(err.Error(), "error reading migrations template") {
+			t.Fatalf("expected missing template error, got %v", err)
+		}
+	})
+}
+
+func TestGenerateInferredMigration(t *testing.T) {
+	bd, _ := os.Getwd()
+	defer os.Chdir(bd)
+
+	testCases := []struct {
+		name     string
+		url      string
+		args     []string
+		expected []string
+	}{
+		{
+			name: "create table sqlite",
+			url:  "test.db",
+			args: []string{"create_users_table", "name:string", "email:string:unique"},
+			expected: []string{
+				"-- +up\nCREATE TABLE users (\n\tid INTEGER PRIMARY KEY AUTOINCREMENT,\n\tname TEXT,\n\temail TEXT\n);\n\nCREATE UNIQUE INDEX users_email_key ON users (email);\n",
+				"-- +down\nDROP TABLE users;\n",
+			},
+		},
+		{
+			name: "create table postgres",
+			url:  "postgres://localhost/app",
+			args: []string{"create_users", "name:string:required", "data:json"},
+			expected: []string{
+				"CREATE TABLE users (\n\tid BIGSERIAL PRIMARY KEY,\n\tname VARCHAR(255) NOT NULL,\n\tdata JSONB\n);\n",
+			},
+		},
+		{
+			name: "add required column sqlite",
+			url:  "test.db",
+			args: []string{"add_email_to_users", "email:string:required"},
+			expected: []string{
+				"-- +up\n-- email is required: fill it in for the existing rows and make it NOT NULL, or give it a DEFAULT and NOT NULL here.\nALTER TABLE users ADD COLUMN email TEXT;\n",
+			},
+		},
+		{
+			name: "add column mysql",
+			url:  "mysql://root@localhost/app",
+			args: []string{"add_age_to_users", "age:integer:index"},
+			expected: []string{
+				"-- +up\nALTER TABLE users ADD COLUMN age INT;\n\nCREATE INDEX users_age_index ON users (age);\n",
+				"-- +down\nDROP INDEX users_age_index ON users;\nALTER TABLE users DROP COLUMN age;\n",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.Chdir(t.TempDir()); err != nil {
+				t.Fatalf("error changing directory: %v", err)
+			}
+
+			os.Setenv("DATABASE_URL", tc.url)
+			args := append([]string{"generate_migration"}, tc.args...)
+			if _, err := runExec(t, append(args, "--migration.folder=migrations")...); err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+
+			files, _ := filepath.Glob("migrations/*.sql")
+			if len(files) != 1 {
+				t.Fatalf("expected one migration, got %v", files)
+			}
+
+			content, _ := os.ReadFile(files[0])
+			for _, expected := range tc.expected {
+				if !strings.Contains(string(content), expected) {
+					t.Fatalf("expected migration to contain %q, got:\n%s", expected, content)
+				}
+			}
+		})
+	}
+
+	t.Run("generated migration runs", func(t *testing.T) {
+		if err := os.Chdir(t.TempDir()); err != nil {
+			t.Fatalf("error changing directory: %v", err)
+		}
+
+		os.Setenv("DATABASE_URL", "test.db")
+		if _, err := runExec(t, "generate_migration", "create_users", "email:string:unique", "--migration.folder=migrations"); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if _, err := runExec(t, "generate_migration", "add_age_to_users", "age:integer:index", "--migration.folder=migrations"); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		out, err := runExec(t, "generate_migration", "add_name_to_users", "name:string:required", "--migration.folder=migrations")
 		if err != nil {
-			t.Fatalf("error creating migration: %v", err)
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		if !strings.Contains(out, "users.name is added without NOT NULL") {
+			t.Fatalf("expected a note about the required column, got: %v", out)
 		}
 
-		w.Close()
-		out, _ := io.ReadAll(r)
-		fmt.Println(string(out))
+		if _, err := runExec(t, "migrate", "--migration.folder=migrations"); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
 
-		if !bytes.Contains(out, []byte("Usage: database generate_migration <migration_name>")) {
-			t.Errorf("Expected 'Usage: database generate_migration <migration_name>', got: %v", string(out))
+		if _, err := runExec(t, "rollback", "--steps=3", "--migration.folder=migrations"); err != nil {
+			t.Fatalf("expected nil, got %v", err)
 		}
 	})
+
+	t.Run("unknown type", func(t *testing.T) {
+		_, err := runExec(t, "generate_migration", "create_users", "age:number")
+		if err == nil || !strings.Contains(err.Error(), `unknown column type "number"`) {
+			t.Fatalf("expected unknown type error, got %v", err)
+		}
+	})
+}
+
+func TestGenerateMigrationNames(t *testing.T) {
+	bd, _ := os.Getwd()
+	defer os.Chdir(bd)
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("error changing directory: %v", err)
+	}
+
+	os.Setenv("DATABASE_URL", "test.db")
+
+	names := map[string]string{
+		"AddIndexToUsers":        "add_index_to_users",
+		"backfill UserID/emails": "backfill_user_id_emails",
+		"Send HTTPRequests!":     "send_http_requests",
+	}
+
+	for name, expected := range names {
+		if _, err := runExec(t, "generate_migration", name, "--migration.folder=migrations"); err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+
+		files, _ := filepath.Glob(filepath.Join("migrations", "*_"+expected+".sql"))
+		if len(files) != 1 {
+			t.Fatalf("expected %q to generate *_%s.sql, got %v", name, expected, files)
+		}
+	}
+
+	// All of them were generated within the same second or so, and
+	// none should have overwritten another.
+	files, _ := filepath.Glob(filepath.Join("migrations", "*.sql"))
+	timestamps := map[string]bool{}
+	for _, file := range files {
+		timestamps[filepath.Base(file)[:14]] = true
+	}
+
+	if len(files) != 3 || len(timestamps) != 3 {
+		t.Fatalf("expected 3 migrations with distinct timestamps, got %v", files)
+	}
+
+	_, err := runExec(t, "generate_migration", "add_index_to_users", "--migration.folder=migrations")
+	if err == nil || !strings.Contains(err.Error(), "migration add_index_to_users already exists") {
+		t.Fatalf("expected duplicate name error, got %v", err)
+	}
+
+	_, err = runExec(t, "generate_migration", "add_index_to_users", "--go", "--migration.folder=migrations")
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected duplicate name error for Go migration, got %v", err)
+	}
+
+	writeMigration(t, filepath.Join("migrations", "posts"), "20240101000000_create_posts.sql", "CREATE TABLE posts (id INTEGER);\n")
+	_, err = runExec(t, "generate_migration", "create_posts", "--migration.folder=migrations")
+	if err == nil || !strings.Contains(err.Error(), "migration create_posts already exists in "+filepath.Join("migrations", "posts")) {
+		t.Fatalf("expected duplicate name error for nested migration, got %v", err)
+	}
+
+	_, err = runExec(t, "generate_migration", "../", "--migration.folder=migrations")
+	if err == nil || !strings.Contains(err.Error(), "invalid migration name") {
+		t.Fatalf("expected invalid name error, got %v", err)
+	}
 }
@@ -0,0 +1,651 @@
+package database
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// migrationExp matches migration file names such as
+// 20060102150405_create_users.sql and its optional
+// 20060102150405_create_users.down.sql sibling.
+var migrationExp = regexp.MustCompile(`^(\d{14})_(.+?)(\.down)?\.sql$`)
+
+// goMigrationExp matches the files of Go migrations generated by
+// `db generate_migration --go`, such as 20060102150405_backfill.go.
+var goMigrationExp = regexp.MustCompile(`^(\d{14})_(.+)\.go$`)
+
+// noTransactionHeader opts a migration file out of running in a
+// transaction, for statements such as CREATE INDEX CONCURRENTLY.
+const noTransactionHeader = "-- leapkit:no-transaction"
+
+// timestampExp matches a migration timestamp.
+var timestampExp = regexp.MustCompile(`^\d{14}$`)
+
+// migrationFunc is the function a Go migration runs in its transaction.
+type migrationFunc func(*sql.Tx) error
+
+// migration is a single migration from the migrations folder
+// with the SQL to apply it and, optionally, the SQL to revert it.
+// Go migrations carry functions instead of SQL and always run in
+// a transaction.
+type migration struct {
+	Timestamp string
+	Name      string
+	Up        string
+	Down      string
+
+	// NoTransaction is set by the no-transaction header.
+	NoTransaction bool
+
+	// Checksum is the hash of the up and down SQL, empty for Go
+	// migrations.
+	Checksum string
+
+	// UpChecksum is the hash of the up SQL alone, the checksum
+	// recorded by older versions.
+	UpChecksum string
+
+	// Squashed are the migrations a baseline replaces, from its
+	// squashed header.
+	Squashed []string
+
+	UpFunc   migrationFunc
+	DownFunc migrationFunc
+}
+
+// reversible reports whether the migration can be rolled back.
+func (m migration) reversible() bool {
+	return m.Down != "" || m.DownFunc != nil
+}
+
+// goMigrations holds the migrations registered with Register.
+var goMigrations = map[string]migration{}
+
+// Register adds a Go migration that runs in timestamp order with
+// the SQL migrations. It panics if the timestamp is not a 14 digit
+// timestamp or if it is registered twice, like database/sql does
+// for drivers.
+func Register(timestamp, name string, up, down func(*sql.Tx) error) {
+	if !timestampExp.MatchString(timestamp) {
+		panic(fmt.Sprintf("migrate: invalid migration timestamp %q", timestamp))
+	}
+
+	if up == nil {
+		panic(fmt.Sprintf("migrate: migration %s has no up function", timestamp))
+	}
+
+	if _, dup := goMigrations[timestamp]; dup {
+		panic(fmt.Sprintf("migrate: Register called twice for migration %s", timestamp))
+	}
+
+	goMigrations[timestamp] = migration{
+		Timestamp: timestamp,
+		Name:      name,
+		UpFunc:    up,
+		DownFunc:  down,
+	}
+}
+
+// loadMigrations reads the migrations in dir, see loadMigrationsFS.
+func loadMigrations(dir string) ([]migration, error) {
+	if _, err := os.Lstat(dir); err != nil {
+		return nil, fmt.Errorf("error walking migrations directory: %w", err)
+	}
+
+	return loadMigrationsFS(os.DirFS(dir))
+}
+
+// loadMigrationsFS reads the migrations in fsys, adds the registered
+// Go migrations and returns them sorted by timestamp. Down SQL is taken
+// from the `-- +down` section of the migration file or from a sibling
+// *.down.sql file. Go migration files in fsys must be registered.
+func loadMigrationsFS(fsys fs.FS) ([]migration, error) {
+	byTimestamp := map[string]*migration{}
+	downs := map[string]string{}
+	goFiles := map[string]string{}
+
+	err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking migrations directory: %w", err)
+		}
+
+		if match := goMigrationExp.FindStringSubmatch(entry.Name()); match != nil && !entry.IsDir() && !strings.HasSuffix(path, "_test.go") {
+			goFiles[match[1]] = match[2]
+			return nil
+		}
+
+		match := migrationExp.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return fmt.Errorf("error reading migration %s: %w", path, err)
+		}
+
+		timestamp, name := match[1], match[2]
+		if match[3] != "" {
+			downs[timestamp] = string(content)
+			return nil
+		}
+
+		if m, ok := byTimestamp[timestamp]; ok {
+			return fmt.Errorf("duplicate migration timestamp %s: %s and %s", timestamp, m.Name, name)
+		}
+
+		up, down := splitSections(string(content))
+		byTimestamp[timestamp] = &migration{
+			Timestamp:     timestamp,
+			Name:          name,
+			Up:            up,
+			Down:          down,
+			NoTransaction: hasNoTransactionHeader(string(content)),
+			Squashed:      squashedTimestamps(string(content)),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for timestamp, down := range downs {
+		m, ok := byTimestamp[timestamp]
+		if !ok {
+			return nil, fmt.Errorf("down migration %s has no matching up migration", timestamp)
+		}
+
+		if m.Down != "" {
+			return nil, fmt.Errorf("migration %s_%s has both a -- +down section and a .down.sql file", m.Timestamp, m.Name)
+		}
+
+		m.Down = strings.TrimSpace(down)
+		m.NoTransaction = m.NoTransaction || hasNoTransactionHeader(down)
+	}
+
+	for _, m := range byTimestamp {
+		m.Checksum = checksum(m.Up, m.Down)
+		m.UpChecksum = checksum(m.Up, "")
+	}
+
+	// Go migrations only run in binaries importing their package,
+	// skipping them would apply the ones after out of order.
+	for _, timestamp := range sortedKeys(goFiles) {
+		if _, ok := goMigrations[timestamp]; !ok {
+			return nil, fmt.Errorf("Go migration %s_%s is not registered, run the migrations from a binary that imports its package, see go.leapkit.dev/tools/db/migrate", timestamp, goFiles[timestamp])
+		}
+	}
+
+	for timestamp, gm := range goMigrations {
+		if m, ok := byTimestamp[timestamp]; ok {
+			return nil, fmt.Errorf("duplicate migration timestamp %s: %s and Go migration %s", timestamp, m.Name, gm.Name)
+		}
+
+		byTimestamp[timestamp] = &gm
+	}
+
+	migrations := make([]migration, 0, len(byTimestamp))
+	for _, m := range byTimestamp {
+		migrations = append(migrations, *m)
+	}
+
+	slices.SortFunc(migrations, func(a, b migration) int {
+		return strings.Compare(a.Timestamp, b.Timestamp)
+	})
+
+	return migrations, nil
+}
+
+// splitSections splits the content of a migration file into its
+// `-- +up` and `-- +down` sections. Content before any marker is
+// part of the up section, so files without markers are up-only.
+func splitSections(content string) (up, down string) {
+	var upLines, downLines []string
+	current := &upLines
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "-- +up":
+			current = &upLines
+			continue
+		case "-- +down":
+			current = &downLines
+			continue
+		}
+
+		*current = append(*current, line)
+	}
+
+	up = strings.TrimSpace(strings.Join(upLines, "\n"))
+	down = strings.TrimSpace(strings.Join(downLines, "\n"))
+
+	return up, down
+}
+
+// checksum returns the hex encoded SHA-256 of the migration SQL. The
+// down SQL is hashed in a `-- +down` section after the up SQL, so
+// up-only migrations keep the checksum older versions recorded.
+func checksum(up, down string) string {
+	sql := up
+	if down != "" {
+		sql += "\n-- +down\n" + down
+	}
+
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
+// hasNoTransactionHeader reports whether the no-transaction header
+// is among the comments at the top of the migration file.
+func hasNoTransactionHeader(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.EqualFold(line, noTransactionHeader) {
+			return true
+		}
+
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+
+	return false
+}
+
+// appliedMigration is a row of the schema_migrations table.
+type appliedMigration struct {
+	Timestamp string
+	Name      string
+	AppliedAt sql.NullTime
+	Checksum  string
+}
+
+// migrator applies and reverts migrations, keeping track of
+// the applied ones in the schema_migrations table.
+type migrator struct {
+	conn    *sql.DB
+	dialect Dialect
+
+	// unlock releases the migrations lock when it is held.
+	unlock func() error
+
+	// logf reports the migrations rolled back.
+	logf func(format string, args ...any)
+}
+
+// Options are the settings for running migrations.
+type Options struct {
+	// LockTimeout is how long to wait for another runner to release
+	// the migrations lock, 30 seconds when zero.
+	LockTimeout time.Duration
+
+	// AllowDrift runs the migrations even if applied ones changed.
+	AllowDrift bool
+
+	// Logf reports progress such as the migrations rolled back.
+	// Nothing is reported when nil.
+	Logf func(format string, args ...any)
+}
+
+// flagOptions returns the options set by the command flags.
+func flagOptions() Options {
+	return Options{
+		LockTimeout: lockTimeout,
+		AllowDrift:  allowDrift,
+		Logf:        printf,
+	}
+}
+
+// newMigrator opens a migrator with the options set by the command
+// flags, see openMigrator.
+func newMigrator(url string) (*migrator, error) {
+	return openMigrator(url, flagOptions())
+}
+
+// openMigrator opens a connection to the database at url, takes the
+// migrations lock, held until Close so concurrent runners don't race
+// on the table, and makes sure the schema_migrations table exists.
+// Commands that only read use openReader instead.
+func openMigrator(url string, opts Options) (*migrator, error) {
+	conn, dialect, err := openConn(url)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &migrator{conn: conn, dialect: dialect, logf: opts.Logf}
+	if m.logf == nil {
+		m.logf = func(string, ...any) {}
+	}
+
+	m.unlock, err = acquireLock(conn, dialect, cmp.Or(opts.LockTimeout, 30*time.Second))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := m.setup(); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// errNoDatabase is returned by openReader for SQLite databases whose
+// file doesn't exist.
+var errNoDatabase = errors.New("database does not exist")
+
+// openReader opens the database at url for commands that only read
+// it. It neither takes the lock nor creates or alters the
+// schema_migrations table, and doesn't create missing SQLite files.
+func openReader(url string) (*migrator, error) {
+	d, err := dialectFor(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Driver == "sqlite3" && !sqliteExists(url) {
+		return nil, fmt.Errorf("%w: %s", errNoDatabase, url)
+	}
+
+	conn, d, err := openConn(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrator{conn: conn, dialect: d, logf: func(string, ...any) {}}, nil
+}
+
+// readApplied returns the applied migrations of the database at url
+// without changing it. A database without the schema_migrations
+// table, or not created yet, has none.
+func readApplied(url string) ([]appliedMigration, error) {
+	m, err := openReader(url)
+	if errors.Is(err, errNoDatabase) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer m.Close()
+
+	if !m.hasTable() {
+		return nil, nil
+	}
+
+	return m.applied()
+}
+
+// Close releases the migrations lock, if held, and closes the
+// underlying connection.
+func (m *migrator) Close() error {
+	if m.unlock != nil {
+		if err := m.unlock(); err != nil {
+			m.conn.Close()
+			return fmt.Errorf("error releasing migrations lock: %w", err)
+		}
+	}
+
+	return m.conn.Close()
+}
+
+// migrationsTableSQL creates the schema_migrations table.
+const migrationsTableSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	timestamp VARCHAR(14) NOT NULL PRIMARY KEY,
+	name VARCHAR(255),
+	applied_at TIMESTAMP,
+	checksum VARCHAR(64)
+)`
+
+// setup creates the schema_migrations table if needed and adds
+// the columns missing from tables created by older versions.
+func (m *migrator) setup() error {
+	_, err := m.conn.Exec(migrationsTableSQL)
+	if err != nil {
+		return fmt.Errorf("error creating migrations table: %w", err)
+	}
+
+	for _, column := range m.missingColumns() {
+		_, err := m.conn.Exec(addColumnSQL(column))
+		if err != nil {
+			return fmt.Errorf("error adding %s to migrations table: %w", column[0], err)
+		}
+	}
+
+	return nil
+}
+
+// migrationsColumns are the schema_migrations columns added after
+// the timestamp, with their types. Tables created by older versions
+// or by core's migrations runner don't have them until setup runs.
+var migrationsColumns = [][2]string{
+	{"name", "VARCHAR(255)"},
+	{"applied_at", "TIMESTAMP"},
+	{"checksum", "VARCHAR(64)"},
+}
+
+// missingColumns returns the migrationsColumns the schema_migrations
+// table does not have.
+func (m *migrator) missingColumns() [][2]string {
+	var missing [][2]string
+	for _, column := range migrationsColumns {
+		if _, err := m.conn.Exec(fmt.Sprintf(`SELECT %s FROM schema_migrations WHERE 1 = 0`, column[0])); err != nil {
+			missing = append(missing, column)
+		}
+	}
+
+	return missing
+}
+
+// addColumnSQL returns the statement adding the column to the
+// schema_migrations table.
+func addColumnSQL(column [2]string) string {
+	return fmt.Sprintf(`ALTER TABLE schema_migrations ADD COLUMN %s %s`, column[0], column[1])
+}
+
+// hasTable reports whether the schema_migrations table exists.
+func (m *migrator) hasTable() bool {
+	_, err := m.conn.Exec(`SELECT timestamp FROM schema_migrations WHERE 1 = 0`)
+	return err == nil
+}
+
+// applied returns the applied migrations sorted by timestamp. Columns
+// the table is missing read as NULL, so it works before setup too.
+func (m *migrator) applied() ([]appliedMigration, error) {
+	selected := []string{"timestamp"}
+	missing := m.missingColumns()
+	for _, column := range migrationsColumns {
+		if slices.Contains(missing, column) {
+			selected = append(selected, "NULL")
+			continue
+		}
+
+		selected = append(selected, column[0])
+	}
+
+	rows, err := m.conn.Query(`SELECT ` + strings.Join(selected, ", ") + ` FROM schema_migrations ORDER BY timestamp`)
+	if err != nil {
+		return nil, fmt.Errorf("error reading applied migrations: %w", err)
+	}
+
+	defer rows.Close()
+
+	var applied []appliedMigration
+	for rows.Next() {
+		var am appliedMigration
+		var name, checksum sql.NullString
+		if err := rows.Scan(&am.Timestamp, &name, &am.AppliedAt, &checksum); err != nil {
+			return nil, fmt.Errorf("error reading applied migrations: %w", err)
+		}
+
+		am.Name = name.String
+		am.Checksum = checksum.String
+		applied = append(applied, am)
+	}
+
+	return applied, rows.Err()
+}
+
+// isApplied reports whether the migration with the given
+// timestamp is in applied.
+func isApplied(applied []appliedMigration, timestamp string) bool {
+	return slices.ContainsFunc(applied, func(am appliedMigration) bool {
+		return am.Timestamp == timestamp
+	})
+}
+
+// up applies the migration and records it as applied, both in the
+// same transaction unless the migration opted out of it.
+func (m *migrator) up(mig migration) error {
+	start := time.Now()
+	apply := func(e execer) error {
+		if err := execSQL(e, mig.Up); err != nil {
+			return err
+		}
+
+		return m.record(e, mig)
+	}
+
+	var err error
+	switch {
+	case mig.UpFunc != nil:
+		err = m.inTx(func(tx *sql.Tx) error {
+			if err := mig.UpFunc(tx); err != nil {
+				return err
+			}
+
+			return m.record(tx, mig)
+		})
+	case mig.NoTransaction:
+		err = apply(m.conn)
+	default:
+		err = m.inTx(func(tx *sql.Tx) error { return apply(tx) })
+	}
+
+	if err != nil {
+		return fmt.Errorf("error running migration %s_%s: %w", mig.Timestamp, mig.Name, err)
+	}
+
+	report.ran(mig, "up", time.Since(start))
+	return nil
+}
+
+// down reverts the migration and removes its record, both in the
+// same transaction unless the migration opted out of it.
+func (m *migrator) down(mig migration) error {
+	start := time.Now()
+	revert := func(e execer) error {
+		if err := execSQL(e, mig.Down); err != nil {
+			return err
+		}
+
+		return m.unrecord(e, mig)
+	}
+
+	var err error
+	switch {
+	case mig.DownFunc != nil:
+		err = m.inTx(func(tx *sql.Tx) error {
+			if err := mig.DownFunc(tx); err != nil {
+				return err
+			}
+
+			return m.unrecord(tx, mig)
+		})
+	case mig.NoTransaction:
+		err = revert(m.conn)
+	default:
+		err = m.inTx(func(tx *sql.Tx) error { return revert(tx) })
+	}
+
+	if err != nil {
+		return fmt.Errorf("error rolling back migration %s_%s: %w", mig.Timestamp, mig.Name, err)
+	}
+
+	report.ran(mig, "down", time.Since(start))
+	return nil
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// record marks the migration as applied.
+func (m *migrator) record(e execer, mig migration) error {
+	checksum := sql.NullString{String: mig.Checksum, Valid: mig.Checksum != ""}
+	_, err := e.Exec(m.dialect.rebind(`INSERT INTO schema_migrations (timestamp, name, applied_at, checksum) VALUES ($1, $2, $3, $4)`), mig.Timestamp, mig.Name, time.Now().UTC(), checksum)
+	return err
+}
+
+// updateChecksums replaces the up-only checksums recorded by older
+// versions with the checksums of the migrations.
+func (m *migrator) updateChecksums(migrations []migration, applied []appliedMigration) error {
+	byTimestamp := map[string]migration{}
+	for _, mig := range migrations {
+		byTimestamp[mig.Timestamp] = mig
+	}
+
+	for _, am := range applied {
+		mig, ok := byTimestamp[am.Timestamp]
+		if !ok || am.Checksum == mig.Checksum || am.Checksum != mig.UpChecksum {
+			continue
+		}
+
+		_, err := m.conn.Exec(m.dialect.rebind(`UPDATE schema_migrations SET checksum = $1 WHERE timestamp = $2`), mig.Checksum, mig.Timestamp)
+		if err != nil {
+			return fmt.Errorf("error updating the checksum of %s_%s: %w", mig.Timestamp, mig.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// unrecord removes the migration from the applied ones.
+func (m *migrator) unrecord(e execer, mig migration) error {
+	_, err := e.Exec(m.dialect.rebind(`DELETE FROM schema_migrations WHERE timestamp = $1`), mig.Timestamp)
+	return err
+}
+
+// inTx runs fn in a transaction, committing it when fn succeeds
+// and rolling it back otherwise.
+func (m *migrator) inTx(fn func(*sql.Tx) error) error {
+	tx, err := m.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// execSQL runs the statements in query, if any.
+func execSQL(e execer, query string) error {
+	if query == "" {
+		return nil
+	}
+
+	_, err := e.Exec(query)
+	return err
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// outputFormat is the format commands print their results in,
+// text or json
+var outputFormat string
+
+// outputFlags registers the --format flag on fs.
+func outputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&outputFormat, "format", "text", "the output format, text or json")
+}
+
+// report is the result of the running command when the output
+// format is json, nil otherwise.
+var report *operationReport
+
+// operationReport is the machine readable result of a command.
+type operationReport struct {
+	Operation  string            `json:"operation"`
+	Database   string            `json:"database,omitempty"`
+	Success    bool              `json:"success"`
+	Migrations []migrationReport `json:"migrations"`
+	StartedAt  time.Time         `json:"started_at"`
+	DurationMS int64             `json:"duration_ms"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// migrationReport is a migration in an operationReport. Direction is
+// set for the migrations a command ran and Status for the ones
+// status lists.
+type migrationReport struct {
+	Timestamp  string `json:"timestamp"`
+	Name       string `json:"name"`
+	Direction  string `json:"direction,omitempty"`
+	Status     string `json:"status,omitempty"`
+	AppliedAt  string `json:"applied_at,omitempty"`
+	DurationMS *int64 `json:"duration_ms,omitempty"`
+}
+
+// setDatabase sets the database the command operates on, with its
+// password redacted.
+func (r *operationReport) setDatabase(url string) {
+	if r == nil {
+		return
+	}
+
+	r.Database = redactURL(url)
+}
+
+// ran adds a migration the command applied or rolled back.
+func (r *operationReport) ran(mig migration, direction string, d time.Duration) {
+	if r == nil {
+		return
+	}
+
+	ms := d.Milliseconds()
+	r.Migrations = append(r.Migrations, migrationReport{
+		Timestamp:  mig.Timestamp,
+		Name:       mig.Name,
+		Direction:  direction,
+		DurationMS: &ms,
+	})
+}
+
+// listed adds a migration with its status, appliedAt is - when
+// the time it was applied is unknown.
+func (r *operationReport) listed(timestamp, name, status, appliedAt string) {
+	if r == nil {
+		return
+	}
+
+	if appliedAt == "-" {
+		appliedAt = ""
+	}
+
+	r.Migrations = append(r.Migrations, migrationReport{
+		Timestamp: timestamp,
+		Name:      name,
+		Status:    status,
+		AppliedAt: appliedAt,
+	})
+}
+
+// write finishes the report with the command error and prints it
+// as JSON.
+func (r *operationReport) write(w io.Writer, err error) error {
+	r.DurationMS = time.Since(r.StartedAt).Milliseconds()
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	if r.Migrations == nil {
+		r.Migrations = []migrationReport{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+
+	return nil
+}
+
+// textOutput returns where commands print their text output, which
+// is left out when the output format is json.
+func textOutput() io.Writer {
+	if report != nil {
+		return io.Discard
+	}
+
+	return os.Stdout
+}
+
+// printf prints text output, see textOutput.
+func printf(format string, args ...any) {
+	fmt.Fprintf(textOutput(), format, args...)
+}
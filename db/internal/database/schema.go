@@ -0,0 +1,154 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// schemaFile is the schema.sql file next to the migrations folder.
+func schemaFile() string {
+	return filepath.Join(filepath.Dir(migrationFolder), "schema.sql")
+}
+
+// dumpSchema writes the schema of the database at url to the schema
+// file, followed by the applied migrations so a database loaded from
+// it does not run them again.
+func dumpSchema(url string) error {
+	m, err := openReader(url)
+	if err != nil {
+		return err
+	}
+
+	defer m.Close()
+
+	if m.dialect.DumpSchema == nil {
+		return fmt.Errorf("dumping %s schemas is not supported", m.dialect.Driver)
+	}
+
+	schema, err := m.dialect.DumpSchema(url, m.conn)
+	if err != nil {
+		return fmt.Errorf("error dumping schema: %w", err)
+	}
+
+	var applied []appliedMigration
+	if m.hasTable() {
+		applied, err = m.applied()
+		if err != nil {
+			return err
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(schema))
+	b.WriteString("\n\n")
+	for _, am := range applied {
+		appliedAt := "NULL"
+		if am.AppliedAt.Valid {
+			appliedAt = quote(am.AppliedAt.Time.UTC().Format("2006-01-02 15:04:05"))
+		}
+
+		checksum := "NULL"
+		if am.Checksum != "" {
+			checksum = quote(am.Checksum)
+		}
+
+		fmt.Fprintf(&b, "INSERT INTO schema_migrations (timestamp, name, applied_at, checksum) VALUES (%s, %s, %s, %s);\n", quote(am.Timestamp), quote(am.Name), appliedAt, checksum)
+	}
+
+	if err := os.WriteFile(schemaFile(), []byte(b.String()), 0o644); err != nil {
+		return fmt.Errorf("error writing schema file: %w", err)
+	}
+
+	return nil
+}
+
+// loadSchema runs the schema file against the database at url.
+func loadSchema(url string) error {
+	schema, err := os.ReadFile(schemaFile())
+	if err != nil {
+		return fmt.Errorf("error reading schema file: %w", err)
+	}
+
+	conn, _, err := openConn(url)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.Exec(string(schema)); err != nil {
+		return fmt.Errorf("error loading schema: %w", err)
+	}
+
+	return nil
+}
+
+// hasSchemaFile reports whether the schema file exists.
+func hasSchemaFile() bool {
+	_, err := os.Stat(schemaFile())
+	return err == nil
+}
+
+// quote returns s as a SQL string literal.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// dumpSQLite returns the statements in sqlite_master in the order
+// they were created, leaving out SQLite internal tables.
+func dumpSQLite(_ string, conn *sql.DB) (string, error) {
+	rows, err := conn.Query(`SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY rowid`)
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	var statements []string
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			return "", err
+		}
+
+		statements = append(statements, stmt+";")
+	}
+
+	return strings.Join(statements, "\n\n"), rows.Err()
+}
+
+// dumpPostgres runs pg_dump --schema-only against url. psql
+// meta-commands are left out so the file can be run by the driver, and
+// so is the search_path reset, which would leave the schema_migrations
+// statements run after it in the same session without a schema. The
+// objects pg_dump creates are schema qualified.
+func dumpPostgres(url string, _ *sql.DB) (string, error) {
+	var stderr strings.Builder
+	cmd := exec.Command("pg_dump", "--schema-only", "--no-owner", "--no-privileges", url)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("pg_dump not found in PATH: %w", err)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("pg_dump: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, `\`) || strings.HasPrefix(line, "SELECT pg_catalog.set_config('search_path'") {
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
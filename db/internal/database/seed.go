@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	// seedFolder is the folder where the seed files are stored
+	seedFolder string
+
+	// seedAfterReset runs the seeds at the end of reset
+	seedAfterReset bool
+
+	// goSeeds holds the seeds registered with RegisterSeed.
+	goSeeds = map[string]func(*sql.Tx) error{}
+)
+
+// seedFlags registers the seed flags on fs.
+func seedFlags(fs *flag.FlagSet) {
+	fs.StringVar(&seedFolder, "seed.folder", filepath.Join("internal", "seeds"), "the folder where the seed files are stored")
+	fs.BoolVar(&seedAfterReset, "seed", false, "run the seeds after resetting the database")
+}
+
+// RegisterSeed adds a Go seed that runs with the SQL seed files in
+// name order. It panics if the name is registered twice.
+func RegisterSeed(name string, fn func(*sql.Tx) error) {
+	if fn == nil {
+		panic(fmt.Sprintf("migrate: seed %s has no function", name))
+	}
+
+	if _, dup := goSeeds[name]; dup {
+		panic(fmt.Sprintf("migrate: RegisterSeed called twice for seed %s", name))
+	}
+
+	goSeeds[name] = fn
+}
+
+// runSeeds runs the .sql files in the seeds folder and the registered
+// Go seeds sorted by name, each one in its own transaction. Seeds are
+// not tracked, they run every time.
+func runSeeds(url string) error {
+	seeds := map[string]func(*sql.Tx) error{}
+	for name, fn := range goSeeds {
+		seeds[name] = fn
+	}
+
+	entries, err := os.ReadDir(seedFolder)
+	if err != nil && (!os.IsNotExist(err) || len(goSeeds) == 0) {
+		return fmt.Errorf("error reading seeds folder: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(seedFolder, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("error reading seed %s: %w", entry.Name(), err)
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		if _, dup := seeds[name]; dup {
+			return fmt.Errorf("duplicate seed %s: %s and Go seed", name, entry.Name())
+		}
+
+		seeds[name] = func(tx *sql.Tx) error {
+			return execSQL(tx, string(content))
+		}
+	}
+
+	conn, _, err := openConn(url)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	m := &migrator{conn: conn}
+	names := make([]string, 0, len(seeds))
+	for name := range seeds {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	for _, name := range names {
+		if err := m.inTx(seeds[name]); err != nil {
+			return fmt.Errorf("error running seed %s: %w", name, err)
+		}
+
+		printf("🌱 Seeded %s\n", name)
+	}
+
+	return nil
+}
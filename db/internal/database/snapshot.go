@@ -0,0 +1,321 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	// snapshotsFolder is where SQLite snapshots are stored.
+	snapshotsFolder = filepath.Join(".leapkit", "snapshots")
+
+	// anonymizeFile holds the anonymization rules for snapshots, the
+	// SQL expression each column is set to, e.g.
+	//
+	//	{
+	//	  "users": {
+	//	    "email": "'user' || id || '@example.com'",
+	//	    "phone": "NULL"
+	//	  }
+	//	}
+	anonymizeFile = filepath.Join(".leapkit", "anonymize.json")
+
+	// anonymizeSnapshot applies the anonymization rules to saved snapshots
+	anonymizeSnapshot bool
+)
+
+// snapshotFlags registers the --anonymize flag on fs.
+func snapshotFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&anonymizeSnapshot, "anonymize", false, "apply the rules in "+anonymizeFile+" to the saved snapshot")
+}
+
+// snapshot saves the database at url as the snapshot with the given
+// name, replacing any previous one, or restores the database from it.
+func snapshot(url, action, name string) error {
+	if !identifierExp.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q, use letters, digits and underscores", name)
+	}
+
+	d, err := dialectFor(url)
+	if err != nil {
+		return err
+	}
+
+	if d.Copy == nil {
+		return fmt.Errorf("snapshots of %s databases are not supported", d.Driver)
+	}
+
+	snapURL, err := snapshotURL(url, name, d.Driver == "sqlite3")
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "save":
+		if anonymizeSnapshot {
+			return saveAnonymized(url, snapURL, name, d)
+		}
+
+		if err := d.Copy(url, snapURL); err != nil {
+			return fmt.Errorf("error saving snapshot: %w", err)
+		}
+
+		return nil
+	case "restore":
+		if !snapshotExists(snapURL, d) {
+			return fmt.Errorf("snapshot %s not found", name)
+		}
+
+		if err := d.Copy(snapURL, url); err != nil {
+			return fmt.Errorf("error restoring snapshot: %w", err)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown snapshot action %q, use save or restore", action)
+	}
+}
+
+// saveAnonymized anonymizes a temporary copy of the database at url
+// and only copies it to snapURL once that succeeded, so a failure
+// never leaves raw data in a snapshot.
+func saveAnonymized(url, snapURL, name string, d Dialect) error {
+	if d.Drop == nil {
+		return fmt.Errorf("anonymized snapshots of %s databases are not supported", d.Driver)
+	}
+
+	tmpURL, err := snapshotURL(url, name+"_anonymizing", d.Driver == "sqlite3")
+	if err != nil {
+		return err
+	}
+
+	defer d.Drop(tmpURL)
+
+	if err := d.Copy(url, tmpURL); err != nil {
+		return fmt.Errorf("error saving snapshot: %w", err)
+	}
+
+	if err := anonymize(tmpURL); err != nil {
+		return err
+	}
+
+	if err := d.Copy(tmpURL, snapURL); err != nil {
+		return fmt.Errorf("error saving snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// snapshotURL returns the URL of the named snapshot of the database
+// at url. SQLite snapshots are files in the snapshots folder, other
+// snapshots are databases named <database>_snapshot_<name>.
+func snapshotURL(url, name string, file bool) (string, error) {
+	if file {
+		base, query, _ := strings.Cut(strings.TrimPrefix(sqlitePath(url), "file:"), "?")
+		if base == "" || strings.Contains(base, ":memory:") {
+			return "", fmt.Errorf("could not derive a snapshot from %s", url)
+		}
+
+		ext := path.Ext(base)
+		snapshot := filepath.Join(snapshotsFolder, strings.TrimSuffix(filepath.Base(base), ext)+"_"+name+ext)
+		if query != "" {
+			snapshot += "?" + query
+		}
+
+		return snapshot, nil
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+
+	database := strings.Trim(u.Path, "/")
+	if database == "" {
+		return "", fmt.Errorf("could not derive a snapshot from %s", redactURL(url))
+	}
+
+	u.Path = "/" + database + "_snapshot_" + name
+	return u.String(), nil
+}
+
+// snapshotExists reports whether the snapshot at url can be
+// connected to.
+func snapshotExists(url string, d Dialect) bool {
+	if d.Driver == "sqlite3" {
+		return sqliteExists(url)
+	}
+
+	conn, _, err := openConn(url)
+	if err != nil {
+		return false
+	}
+
+	defer conn.Close()
+
+	return conn.Ping() == nil
+}
+
+// anonymize sets the columns in the anonymization rules to their
+// expressions in the database at url, all in one transaction.
+func anonymize(url string) error {
+	content, err := os.ReadFile(anonymizeFile)
+	if err != nil {
+		return fmt.Errorf("error reading anonymization rules: %w", err)
+	}
+
+	rules := map[string]map[string]string{}
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return fmt.Errorf("error parsing %s: %w", anonymizeFile, err)
+	}
+
+	conn, _, err := openConn(url)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	m := &migrator{conn: conn}
+	return m.inTx(func(tx *sql.Tx) error {
+		for _, table := range sortedKeys(rules) {
+			if !identifierExp.MatchString(table) {
+				return fmt.Errorf("invalid table name %q in %s", table, anonymizeFile)
+			}
+
+			var sets []string
+			for _, column := range sortedKeys(rules[table]) {
+				if !identifierExp.MatchString(column) {
+					return fmt.Errorf("invalid column name %q in %s", column, anonymizeFile)
+				}
+
+				sets = append(sets, column+" = "+rules[table][column])
+			}
+
+			if len(sets) == 0 {
+				continue
+			}
+
+			if _, err := tx.Exec("UPDATE " + table + " SET " + strings.Join(sets, ", ")); err != nil {
+				return fmt.Errorf("error anonymizing %s: %w", table, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+	return keys
+}
+
+// copySQLite replaces the SQLite database at dst with a copy of src
+// using the online backup API, so src can be in use while it is
+// copied.
+func copySQLite(src, dst string) error {
+	dst = sqlitePath(dst)
+	if dir := filepath.Dir(strings.TrimPrefix(strings.SplitN(dst, "?", 2)[0], "file:")); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	srcDB, err := sql.Open("sqlite3", sqlitePath(src))
+	if err != nil {
+		return err
+	}
+
+	defer srcDB.Close()
+
+	dstDB, err := sql.Open("sqlite3", dst)
+	if err != nil {
+		return err
+	}
+
+	defer dstDB.Close()
+
+	ctx := context.Background()
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer srcConn.Close()
+
+	dstConn, err := dstDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer dstConn.Close()
+
+	return dstConn.Raw(func(dstRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			backup, err := dstRaw.(*sqlite3.SQLiteConn).Backup("main", srcRaw.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+
+			return backup.Finish()
+		})
+	})
+}
+
+// copyPostgres drops the database in dst and creates it again from
+// the one in src with CREATE DATABASE ... TEMPLATE, which needs both
+// to have no other connections.
+func copyPostgres(src, dst string) error {
+	srcURL, err := neturl.Parse(src)
+	if err != nil {
+		return fmt.Errorf("error parsing postgres url: %w", err)
+	}
+
+	dstURL, err := neturl.Parse(dst)
+	if err != nil {
+		return fmt.Errorf("error parsing postgres url: %w", err)
+	}
+
+	quoteIdent := func(name string) string {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+
+	server := *srcURL
+	server.Path = "/postgres"
+	conn, err := sql.Open("postgres", server.String())
+	if err != nil {
+		return fmt.Errorf("error opening connection: %w", err)
+	}
+
+	defer conn.Close()
+
+	dstName := quoteIdent(strings.Trim(dstURL.Path, "/"))
+	if _, err := conn.Exec("DROP DATABASE IF EXISTS " + dstName); err != nil {
+		return err
+	}
+
+	_, err = conn.Exec("CREATE DATABASE " + dstName + " TEMPLATE " + quoteIdent(strings.Trim(srcURL.Path, "/")))
+	return err
+}
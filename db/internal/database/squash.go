@@ -0,0 +1,259 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// squashedHeader lists the migrations a baseline migration replaces,
+// so databases that applied them record the baseline instead.
+const squashedHeader = "-- leapkit:squashed"
+
+// squashBefore is the timestamp db squash squashes the migrations before
+var squashBefore string
+
+// squashFlags registers the --before flag on fs.
+func squashFlags(fs *flag.FlagSet) {
+	fs.StringVar(&squashBefore, "before", "", "squash the migrations with a timestamp before this one")
+}
+
+// squashedTimestamps returns the timestamps in the squashed header
+// among the comments at the top of the migration file.
+func squashedTimestamps(content string) []string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, squashedHeader); ok {
+			return strings.Fields(rest)
+		}
+
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// squashedApplied returns the applied migrations with the ones squashed
+// into a baseline replaced by it, and the baselines that replaced
+// them. A baseline replaces its migrations only when the last one,
+// which it takes the timestamp of, was applied.
+func squashedApplied(migrations []migration, applied []appliedMigration) ([]appliedMigration, []migration, error) {
+	var baselines []migration
+	for _, mig := range migrations {
+		if len(mig.Squashed) == 0 {
+			continue
+		}
+
+		last := slices.IndexFunc(applied, func(am appliedMigration) bool {
+			return am.Timestamp == mig.Timestamp
+		})
+
+		squashed := slices.ContainsFunc(applied, func(am appliedMigration) bool {
+			return am.Timestamp != mig.Timestamp && slices.Contains(mig.Squashed, am.Timestamp)
+		})
+
+		switch {
+		case last == -1 && squashed:
+			return nil, nil, fmt.Errorf("the database applied only some of the migrations squashed into %s_%s, migrate it with the original files first", mig.Timestamp, mig.Name)
+		case last == -1, !squashed && applied[last].Name == mig.Name:
+			continue
+		}
+
+		baseline := appliedMigration{
+			Timestamp: mig.Timestamp,
+			Name:      mig.Name,
+			AppliedAt: applied[last].AppliedAt,
+			Checksum:  mig.Checksum,
+		}
+
+		applied = slices.DeleteFunc(slices.Clone(applied), func(am appliedMigration) bool {
+			return slices.Contains(mig.Squashed, am.Timestamp)
+		})
+
+		applied = append(applied, baseline)
+		baselines = append(baselines, mig)
+	}
+
+	slices.SortFunc(applied, func(a, b appliedMigration) int {
+		return strings.Compare(a.Timestamp, b.Timestamp)
+	})
+
+	return applied, baselines, nil
+}
+
+// replaceSquashed records the baselines in place of the applied
+// migrations they squashed and returns the applied migrations.
+func (m *migrator) replaceSquashed(migrations []migration, applied []appliedMigration) ([]appliedMigration, error) {
+	applied, baselines, err := squashedApplied(migrations, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, baseline := range baselines {
+		err := m.inTx(func(tx *sql.Tx) error {
+			for _, timestamp := range baseline.Squashed {
+				if _, err := tx.Exec(m.dialect.rebind(`DELETE FROM schema_migrations WHERE timestamp = $1`), timestamp); err != nil {
+					return err
+				}
+			}
+
+			return m.record(tx, baseline)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error recording baseline %s_%s: %w", baseline.Timestamp, baseline.Name, err)
+		}
+	}
+
+	return applied, nil
+}
+
+// squashMigrations replaces the migrations before the given timestamp
+// with a baseline migration holding the schema they build, and records
+// the baseline in the database at url. The baseline takes the
+// timestamp of the last squashed migration. Nothing changes when the
+// database applied only some of the squashed migrations.
+func squashMigrations(url, before string) error {
+	if !timestampExp.MatchString(before) {
+		return fmt.Errorf("invalid --before timestamp %q, expected 20060102150405", before)
+	}
+
+	migrations, err := loadMigrations(migrationFolder)
+	if err != nil {
+		return err
+	}
+
+	var squashed []string
+	for _, mig := range migrations {
+		if mig.Timestamp >= before {
+			break
+		}
+
+		if mig.UpFunc != nil {
+			return fmt.Errorf("migration %s_%s is a Go migration and can't be squashed", mig.Timestamp, mig.Name)
+		}
+
+		// Squashing a baseline again keeps the migrations it replaced.
+		squashed = append(squashed, mig.Squashed...)
+		squashed = append(squashed, mig.Timestamp)
+	}
+
+	if len(squashed) == 0 {
+		return fmt.Errorf("no migrations before %s to squash", before)
+	}
+
+	slices.Sort(squashed)
+	squashed = slices.Compact(squashed)
+
+	d, err := dialectFor(url)
+	if err != nil {
+		return err
+	}
+
+	if d.DumpSchema == nil {
+		return fmt.Errorf("dumping %s schemas is not supported", d.Driver)
+	}
+
+	// The database is checked under the lock before any file changes,
+	// the baseline can only replace all of the squashed migrations.
+	m, err := newMigrator(url)
+	if err != nil {
+		return err
+	}
+
+	defer m.Close()
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	last := squashed[len(squashed)-1]
+	squashedMigrations := slices.DeleteFunc(slices.Clone(migrations), func(mig migration) bool {
+		return mig.Timestamp < before
+	})
+
+	squashedMigrations = append(squashedMigrations, migration{Timestamp: last, Name: "baseline", Squashed: squashed})
+	if _, _, err := squashedApplied(squashedMigrations, applied); err != nil {
+		return err
+	}
+
+	scratchURL, cleanup, err := scratchDatabase(url, d)
+	if err != nil {
+		return err
+	}
+
+	defer cleanup()
+
+	if err := migrateScratch(scratchURL, last); err != nil {
+		return err
+	}
+
+	schema, err := dumpScratch(scratchURL, d)
+	if err != nil {
+		return err
+	}
+
+	files, err := migrationFiles(migrationFolder)
+	if err != nil {
+		return err
+	}
+
+	// The squashed files are only removed once the baseline holding
+	// them is written.
+	content := fmt.Sprintf("%s %s\n-- +up\n%s\n", squashedHeader, strings.Join(squashed, " "), strings.TrimSpace(schema))
+	path := filepath.Join(migrationFolder, last+"_baseline.sql")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("error writing baseline migration: %w", err)
+	}
+
+	for _, file := range files {
+		if file.timestamp >= before || file.path == path {
+			continue
+		}
+
+		if err := os.Remove(file.path); err != nil {
+			return fmt.Errorf("error removing squashed migration: %w", err)
+		}
+	}
+
+	printf("📦 Squashed %d migration(s) into %s\n", len(squashed), path)
+
+	migrations, err = loadMigrations(migrationFolder)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.replaceSquashed(migrations, applied)
+	return err
+}
+
+// dumpScratch returns the schema of the scratch database at url
+// without the migrations bookkeeping tables.
+func dumpScratch(url string, d Dialect) (string, error) {
+	conn, _, err := openConn(url)
+	if err != nil {
+		return "", err
+	}
+
+	defer conn.Close()
+
+	for _, table := range []string{"schema_migrations", "schema_migrations_lock"} {
+		if _, err := conn.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return "", fmt.Errorf("error dropping %s: %w", table, err)
+		}
+	}
+
+	schema, err := d.DumpSchema(url, conn)
+	if err != nil {
+		return "", fmt.Errorf("error dumping schema: %w", err)
+	}
+
+	return schema, nil
+}
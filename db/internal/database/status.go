@@ -0,0 +1,107 @@
+package database
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+)
+
+// MigrationStatus is a migration with its state: applied, pending,
+// or missing when it was applied but is no longer among the
+// migrations.
+type MigrationStatus struct {
+	Timestamp string
+	Name      string
+	State     string
+
+	// AppliedAt is zero for pending migrations and for the ones
+	// applied without recording the time.
+	AppliedAt time.Time
+}
+
+// migrationStatuses returns the status of the migrations in the
+// database at url, followed by the applied ones not in migrations.
+func migrationStatuses(url string, migrations []migration) ([]MigrationStatus, error) {
+	applied, err := readApplied(url)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, _, err = squashedApplied(migrations, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[string]time.Time{}
+	for _, am := range applied {
+		appliedAt[am.Timestamp] = am.AppliedAt.Time
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	onDisk := map[string]bool{}
+	for _, mig := range migrations {
+		onDisk[mig.Timestamp] = true
+
+		at, ok := appliedAt[mig.Timestamp]
+		if !ok {
+			statuses = append(statuses, MigrationStatus{Timestamp: mig.Timestamp, Name: mig.Name, State: "pending"})
+			continue
+		}
+
+		statuses = append(statuses, MigrationStatus{Timestamp: mig.Timestamp, Name: mig.Name, State: "applied", AppliedAt: at})
+	}
+
+	// Applied migrations whose file is no longer in the folder.
+	for _, am := range applied {
+		if onDisk[am.Timestamp] {
+			continue
+		}
+
+		statuses = append(statuses, MigrationStatus{Timestamp: am.Timestamp, Name: am.Name, State: "missing", AppliedAt: am.AppliedAt.Time})
+	}
+
+	return statuses, nil
+}
+
+// migrationStatus prints every migration in the migrations folder
+// and every applied migration with its applied state. It returns an
+// error when there are pending migrations so it can gate CI.
+func migrationStatus(url string) error {
+	migrations, err := loadMigrations(migrationFolder)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := migrationStatuses(url, migrations)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(textOutput(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIMESTAMP\tNAME\tSTATUS\tAPPLIED AT")
+
+	var pending int
+	for _, status := range statuses {
+		at := "-"
+		if !status.AppliedAt.IsZero() {
+			at = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+
+		if status.State == "pending" {
+			pending++
+		}
+
+		report.listed(status.Timestamp, status.Name, status.State, at)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", status.Timestamp, status.Name, status.State, at)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error printing migrations status: %w", err)
+	}
+
+	if pending > 0 {
+		return fmt.Errorf("%d pending migration(s)", pending)
+	}
+
+	return nil
+}
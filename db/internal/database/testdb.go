@@ -0,0 +1,93 @@
+package database
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// testDatabaseURL returns the URL of the test database for url. It
+// is TEST_DATABASE_URL (TEST_DATABASE_URL_<NAME> for named databases)
+// or url with _test appended to the database name.
+func testDatabaseURL(url string) (string, error) {
+	env := "TEST_DATABASE_URL"
+	if databaseName != "" {
+		env += "_" + strings.ToUpper(strings.ReplaceAll(databaseName, "-", "_"))
+	}
+
+	if testURL := os.Getenv(env); testURL != "" {
+		if testURL == url {
+			return "", fmt.Errorf("%s points to the development database", env)
+		}
+
+		return testURL, nil
+	}
+
+	d, err := dialectFor(url)
+	if err != nil {
+		return "", err
+	}
+
+	testURL, err := withTestSuffix(url, d.Driver == "sqlite3")
+	if err != nil || testURL == url {
+		return "", fmt.Errorf("could not derive a test database from %s, set %s", redactURL(url), env)
+	}
+
+	return testURL, nil
+}
+
+// withTestSuffix appends _test to the database name in url. For
+// SQLite the name is the file name without its extension.
+func withTestSuffix(url string, file bool) (string, error) {
+	if file {
+		name, query, _ := strings.Cut(url, "?")
+		if strings.Contains(name, ":memory:") || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ":") {
+			return url, nil
+		}
+
+		ext := path.Ext(name)
+		name = strings.TrimSuffix(name, ext) + "_test" + ext
+		if query != "" {
+			name += "?" + query
+		}
+
+		return name, nil
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.Trim(u.Path, "/") == "" {
+		return url, nil
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "_test"
+	return u.String(), nil
+}
+
+// prepareTestDatabase drops and recreates the test database and
+// applies every migration to it.
+func prepareTestDatabase(url string) (string, error) {
+	testURL, err := testDatabaseURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	if err := dropDatabase(testURL); err != nil {
+		return "", fmt.Errorf("error dropping test database: %w", err)
+	}
+
+	if err := createDatabase(testURL); err != nil {
+		return "", fmt.Errorf("error creating test database: %w", err)
+	}
+
+	if err := runMigrations(testURL, ""); err != nil {
+		return "", err
+	}
+
+	return testURL, nil
+}
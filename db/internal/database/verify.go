@@ -0,0 +1,119 @@
+package database
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	flag "github.com/spf13/pflag"
+)
+
+// allowDrift lets migrations run when applied files changed
+var allowDrift bool
+
+// verifyFlags registers the --allow-drift flag on fs.
+func verifyFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&allowDrift, "allow-drift", false, "run migrations even if applied migration files changed")
+}
+
+// driftIssue is an applied migration that doesn't match the
+// migrations folder.
+type driftIssue struct {
+	Timestamp string
+	Name      string
+
+	// Kind is mismatched when the file changed after it was applied,
+	// missing when the file is gone and unknown when no checksum was
+	// recorded, e.g. for migrations applied by older versions.
+	Kind string
+}
+
+// detectDrift compares the applied migrations with the migrations
+// folder. Go migrations have no checksum and are only checked for
+// presence, and the up-only checksums older versions recorded still
+// match until migrate updates them.
+func detectDrift(migrations []migration, applied []appliedMigration) []driftIssue {
+	byTimestamp := map[string]migration{}
+	for _, mig := range migrations {
+		byTimestamp[mig.Timestamp] = mig
+	}
+
+	var issues []driftIssue
+	for _, am := range applied {
+		mig, ok := byTimestamp[am.Timestamp]
+		switch {
+		case !ok:
+			issues = append(issues, driftIssue{am.Timestamp, am.Name, "missing"})
+		case mig.Checksum == "":
+		case am.Checksum == "":
+			issues = append(issues, driftIssue{am.Timestamp, mig.Name, "unknown"})
+		case am.Checksum != mig.Checksum && am.Checksum != mig.UpChecksum:
+			issues = append(issues, driftIssue{am.Timestamp, mig.Name, "mismatched"})
+		}
+	}
+
+	return issues
+}
+
+// checkDrift returns an error when an applied migration file changed
+// since it was applied, unless drift is allowed.
+func checkDrift(migrations []migration, applied []appliedMigration, allow bool) error {
+	if allow {
+		return nil
+	}
+
+	for _, issue := range detectDrift(migrations, applied) {
+		if issue.Kind == "mismatched" {
+			return fmt.Errorf("migration %s_%s changed after it was applied, run `db verify` for details or pass --allow-drift", issue.Timestamp, issue.Name)
+		}
+	}
+
+	return nil
+}
+
+// verifyMigrations prints the applied migrations that don't match the
+// migrations folder. It returns an error when a file changed or is
+// missing; migrations without a recorded checksum are only reported.
+func verifyMigrations(url string) error {
+	migrations, err := loadMigrations(migrationFolder)
+	if err != nil {
+		return err
+	}
+
+	applied, err := readApplied(url)
+	if err != nil {
+		return err
+	}
+
+	applied, _, err = squashedApplied(migrations, applied)
+	if err != nil {
+		return err
+	}
+
+	issues := detectDrift(migrations, applied)
+	if len(issues) == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(textOutput(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIMESTAMP\tNAME\tISSUE")
+
+	var failed int
+	for _, issue := range issues {
+		if issue.Kind != "unknown" {
+			failed++
+		}
+
+		report.listed(issue.Timestamp, issue.Name, issue.Kind, "")
+		fmt.Fprintf(w, "%s\t%s\t%s\n", issue.Timestamp, issue.Name, issue.Kind)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error printing migrations drift: %w", err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d migration(s) drifted from the migrations folder", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	// waitTimeout is how long db wait and --wait wait for the database
+	waitTimeout time.Duration
+
+	// waitForReady waits for the database before migrate, create and reset
+	waitForReady bool
+)
+
+// waitFlags registers the --timeout and --wait flags on fs.
+func waitFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&waitTimeout, "timeout", 30*time.Second, "how long to wait for the database to accept connections")
+	fs.BoolVar(&waitForReady, "wait", false, "wait for the database to accept connections before running, see --timeout")
+}
+
+// waitForDatabase pings the database at url with backoff until it
+// accepts connections or timeout passes. A server answering that the
+// database does not exist is ready too, so it can be created. SQLite
+// databases are files and are always ready.
+func waitForDatabase(url string, timeout time.Duration) error {
+	conn, d, err := openConn(url)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if d.Driver == "sqlite3" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	delay := 100 * time.Millisecond
+	for {
+		err := conn.PingContext(ctx)
+		if err == nil || missingDatabase(err) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out after %s waiting for %s to accept connections: %w", timeout, redactURL(url), err)
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, 2*time.Second)
+	}
+}
+
+// missingDatabase reports whether err is the server saying the
+// database does not exist.
+func missingDatabase(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "3D000"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1049
+	}
+
+	return false
+}
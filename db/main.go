@@ -5,13 +5,16 @@ import (
 	"os"
 
 	"go.leapkit.dev/tools/db/internal/database"
+
+	// Loading .env file
+	_ "go.leapkit.dev/core/tools/envload"
 )
 
 func main() {
 	err := database.Exec()
 	if err != nil {
-		fmt.Printf("%v\n", err)
-		fmt.Println("❌ Operation failed.")
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		fmt.Fprintln(os.Stderr, "❌ Operation failed.")
 
 		// Exit with error code 1 to signal failure
 		// on requested operation.
@@ -0,0 +1,53 @@
+package migrate
+
+import (
+	"io/fs"
+
+	"go.leapkit.dev/tools/db/internal/database"
+)
+
+// Options are the settings for Run, RunTo and Rollback. The zero
+// value waits 30 seconds for the migrations lock, refuses to run when
+// applied migrations changed and reports nothing.
+type Options = database.Options
+
+// Status is a migration with its state: applied, pending, or missing
+// when it was applied but is not among the migrations anymore.
+type Status = database.MigrationStatus
+
+// Run applies the pending migrations in fsys, together with the
+// registered Go migrations, to the database at url. It takes the same
+// lock as `db migrate`, so app instances booting at once can all call
+// it. Migration files may be anywhere in fsys, which makes embedded
+// folders work as they are:
+//
+//	//go:embed migrations
+//	var migrations embed.FS
+//
+//	func main() {
+//		err := migrate.Run(os.Getenv("DATABASE_URL"), migrations, migrate.Options{})
+//		...
+//	}
+func Run(url string, fsys fs.FS, opts Options) error {
+	return database.MigrateFS(url, fsys, "", opts)
+}
+
+// RunTo migrates the database at url up or down to the migration with
+// the given timestamp, see Run.
+func RunTo(url string, fsys fs.FS, target string, opts Options) error {
+	return database.MigrateFS(url, fsys, target, opts)
+}
+
+// Rollback reverts the last steps applied migrations in the database
+// at url, taking their down SQL from fsys. Nothing is reverted if one
+// of them can't be.
+func Rollback(url string, fsys fs.FS, steps int, opts Options) error {
+	return database.RollbackFS(url, fsys, steps, opts)
+}
+
+// Statuses returns the migrations in fsys and the registered Go
+// migrations with their state in the database at url, followed by the
+// applied migrations missing from them.
+func Statuses(url string, fsys fs.FS) ([]Status, error) {
+	return database.StatusFS(url, fsys)
+}
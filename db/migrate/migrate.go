@@ -0,0 +1,92 @@
+// Package migrate lets applications write migrations in Go. Go
+// migrations live in the migrations folder next to the SQL ones and
+// run with them in timestamp order.
+//
+// Go migrations are compiled code, so they only run in binaries that
+// import the package holding them. To run them with the db commands,
+// build a small command that imports that package and calls Exec,
+// loading the .env file like the db command does:
+//
+//	package main
+//
+//	import (
+//		"fmt"
+//		"os"
+//
+//		_ "example.com/app/internal/migrations"
+//		_ "go.leapkit.dev/core/tools/envload"
+//		"go.leapkit.dev/tools/db/migrate"
+//	)
+//
+//	func main() {
+//		if err := migrate.Exec(); err != nil {
+//			fmt.Println(err)
+//			os.Exit(1)
+//		}
+//	}
+//
+// Production binaries can embed the migrations folder and apply it on
+// boot with Run. Rollback and Statuses work on embedded folders too.
+package migrate
+
+import (
+	"database/sql"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"go.leapkit.dev/tools/db/internal/database"
+)
+
+// fileExp matches the name of a Go migration file generated by
+// `db generate_migration --go`.
+var fileExp = regexp.MustCompile(`^\d{14}_(.+)\.go$`)
+
+// Register adds a Go migration with the given timestamp. The up
+// and down functions run in a transaction that also records the
+// migration. down may be nil for migrations that can't be rolled
+// back. The migration name is taken from the file calling Register.
+//
+// Register is meant to be called from init functions and panics if
+// the timestamp is invalid or already registered.
+func Register(timestamp string, up, down func(*sql.Tx) error) {
+	name := "go_migration"
+	if _, file, _, ok := runtime.Caller(1); ok {
+		name = strings.TrimSuffix(filepath.Base(file), ".go")
+		if match := fileExp.FindStringSubmatch(filepath.Base(file)); match != nil {
+			name = match[1]
+		}
+	}
+
+	database.Register(timestamp, name, up, down)
+}
+
+// RegisterSeed adds a Go seed that `db seed` runs together with the
+// .sql files in the seeds folder, sorted by name. fn runs in its own
+// transaction every time the seeds run. RegisterSeed panics if the
+// name is already registered.
+func RegisterSeed(name string, fn func(*sql.Tx) error) {
+	database.RegisterSeed(name, fn)
+}
+
+// Dialect describes how to connect to, create and drop a kind of
+// database. See RegisterDialect.
+type Dialect = database.Dialect
+
+// RegisterDialect makes the db commands support database URLs with
+// the given scheme, for example to use a driver the db command does
+// not bundle:
+//
+//	migrate.RegisterDialect("libsql", migrate.Dialect{Driver: "libsql"})
+func RegisterDialect(scheme string, d Dialect) {
+	database.RegisterDialect(scheme, d)
+}
+
+// Exec runs the db command with the registered Go migrations,
+// reading the command and flags from os.Args. The flags are parsed
+// on their own set, not pflag's CommandLine, and the .env file is not
+// loaded, see the package example.
+func Exec() error {
+	return database.Exec()
+}
@@ -0,0 +1,251 @@
+package migrate_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"go.leapkit.dev/tools/db/migrate"
+)
+
+func init() {
+	migrate.Register("20240102000000", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO users (name) VALUES ('leapkit')`)
+		return err
+	}, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM users WHERE name = 'leapkit'`)
+		return err
+	})
+}
+
+func countUsers(t *testing.T, conn *sql.DB) int {
+	t.Helper()
+
+	var count int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		t.Fatalf("error counting users: %v", err)
+	}
+
+	return count
+}
+
+func TestRegister(t *testing.T) {
+	dir := t.TempDir()
+	url := filepath.Join(dir, "test.db")
+	os.Setenv("DATABASE_URL", url)
+
+	folder := filepath.Join(dir, "migrations")
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		t.Fatalf("error creating migrations folder: %v", err)
+	}
+
+	err := os.WriteFile(filepath.Join(folder, "20240101000000_create_users.sql"), []byte("CREATE TABLE users (name TEXT);"), 0o644)
+	if err != nil {
+		t.Fatalf("error writing migration: %v", err)
+	}
+
+	// The file of the registered Go migration lives next to the SQL ones.
+	err = os.WriteFile(filepath.Join(folder, "20240102000000_migrate_test.go"), []byte("package migrations\n"), 0o644)
+	if err != nil {
+		t.Fatalf("error writing migration: %v", err)
+	}
+
+	os.Args = []string{"db", "migrate", "--migration.folder", folder}
+	if err := migrate.Exec(); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	conn, err := sql.Open("sqlite3", url)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+
+	defer conn.Close()
+
+	if count := countUsers(t, conn); count != 1 {
+		t.Fatalf("expected Go migration to insert 1 user, got %d", count)
+	}
+
+	var name string
+	if err := conn.QueryRow(`SELECT name FROM schema_migrations WHERE timestamp = '20240102000000'`).Scan(&name); err != nil {
+		t.Fatalf("error reading migration record: %v", err)
+	}
+
+	if name != "migrate_test" {
+		t.Fatalf("expected migration name migrate_test, got %s", name)
+	}
+
+	os.Args = []string{"db", "rollback", "--migration.folder", folder, "--steps", "1"}
+	if err := migrate.Exec(); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if count := countUsers(t, conn); count != 0 {
+		t.Fatalf("expected Go migration to be rolled back, got %d users", count)
+	}
+}
+
+func TestRegisterDialect(t *testing.T) {
+	dir := t.TempDir()
+	migrate.RegisterDialect("custom", migrate.Dialect{
+		Driver: "sqlite3",
+		DSN: func(url string) (string, error) {
+			return filepath.Join(dir, strings.TrimPrefix(url, "custom://")), nil
+		},
+	})
+
+	err := os.WriteFile(filepath.Join(dir, "20240101000000_create_users.sql"), []byte("CREATE TABLE users (name TEXT);"), 0o644)
+	if err != nil {
+		t.Fatalf("error writing migration: %v", err)
+	}
+
+	os.Setenv("DATABASE_URL", "custom://app.db")
+	os.Args = []string{"db", "migrate", "--migration.folder", dir}
+	if err := migrate.Exec(); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "app.db")); err != nil {
+		t.Fatalf("expected custom dialect to open app.db: %v", err)
+	}
+
+	os.Args = []string{"db", "create"}
+	err = migrate.Exec()
+	if err == nil || err.Error() != "error creating database: creating sqlite3 databases is not supported" {
+		t.Fatalf("expected create to be unsupported, got %v", err)
+	}
+}
+
+func TestRegisterSeed(t *testing.T) {
+	dir := t.TempDir()
+	url := filepath.Join(dir, "test.db")
+	os.Setenv("DATABASE_URL", url)
+
+	conn, err := sql.Open("sqlite3", url)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+
+	defer conn.Close()
+
+	if _, err := conn.Exec(`CREATE TABLE users (name TEXT)`); err != nil {
+		t.Fatalf("error creating users: %v", err)
+	}
+
+	migrate.RegisterSeed("users", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO users (name) VALUES ('seeded')`)
+		return err
+	})
+
+	// Go seeds run even when there is no seeds folder.
+	os.Args = []string{"db", "seed", "--seed.folder", filepath.Join(dir, "seeds")}
+	if err := migrate.Exec(); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if count := countUsers(t, conn); count != 1 {
+		t.Fatalf("expected Go seed to insert 1 user, got %d", count)
+	}
+}
+
+func TestRun(t *testing.T) {
+	url := filepath.Join(t.TempDir(), "test.db")
+	fsys := fstest.MapFS{
+		"migrations/20240101000000_create_users.sql": {Data: []byte("-- +up\nCREATE TABLE users (name TEXT);\n-- +down\nDROP TABLE users;\n")},
+		"migrations/20240103000000_add_email.sql":    {Data: []byte("-- +up\nALTER TABLE users ADD COLUMN email TEXT;\n-- +down\nALTER TABLE users DROP COLUMN email;\n")},
+	}
+
+	if err := migrate.Run(url, fsys, migrate.Options{}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	statuses, err := migrate.Statuses(url, fsys)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	var states []string
+	for _, s := range statuses {
+		states = append(states, s.Timestamp+" "+s.Name+" "+s.State)
+	}
+
+	expected := "20240101000000 create_users applied,20240102000000 migrate_test applied,20240103000000 add_email applied"
+	if strings.Join(states, ",") != expected {
+		t.Fatalf("expected %s, got %v", expected, states)
+	}
+
+	if err := migrate.RunTo(url, fsys, "20240102000000", migrate.Options{}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	err = migrate.RunTo(url, fsys, "20990101000000", migrate.Options{})
+	if err == nil || err.Error() != "migration 20990101000000 not found in the migrations fs.FS" {
+		t.Fatalf("expected missing target error, got %v", err)
+	}
+
+	var logs []string
+	opts := migrate.Options{
+		Logf: func(format string, args ...any) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		},
+	}
+
+	if err := migrate.Rollback(url, fsys, 2, opts); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	if strings.Join(logs, "") != "⏪ Rolled back 20240102000000_migrate_test\n⏪ Rolled back 20240101000000_create_users\n" {
+		t.Fatalf("unexpected rollback logs %q", logs)
+	}
+
+	statuses, err = migrate.Statuses(url, fsys)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	for _, s := range statuses {
+		if s.State != "pending" || !s.AppliedAt.IsZero() {
+			t.Fatalf("expected every migration to be pending, got %+v", s)
+		}
+	}
+
+	t.Run("lock timeout", func(t *testing.T) {
+		conn, err := sql.Open("sqlite3", url)
+		if err != nil {
+			t.Fatalf("error opening database: %v", err)
+		}
+
+		defer conn.Close()
+
+		// Another runner holding the lock.
+		_, err = conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations_lock (id INTEGER NOT NULL PRIMARY KEY, locked_at TIMESTAMP);
+			INSERT INTO schema_migrations_lock (id, locked_at) VALUES (1, CURRENT_TIMESTAMP);`)
+		if err != nil {
+			t.Fatalf("error taking the lock: %v", err)
+		}
+
+		err = migrate.Run(url, fsys, migrate.Options{LockTimeout: 100 * time.Millisecond})
+		if err == nil || !strings.Contains(err.Error(), "timed out after 100ms waiting for the migrations lock") {
+			t.Fatalf("expected lock timeout error, got %v", err)
+		}
+	})
+}
+
+func TestAppFlags(t *testing.T) {
+	// Importing the package leaves the app's flags alone, even ones
+	// named like the db flags.
+	if flag.CommandLine.Lookup("timeout") != nil {
+		t.Fatal("expected no db flags on the command line flag set")
+	}
+
+	timeout := flag.Duration("timeout", time.Second, "the app's own timeout")
+	if err := flag.CommandLine.Parse([]string{"--timeout=2s"}); err != nil || *timeout != 2*time.Second {
+		t.Fatalf("expected the app's timeout flag, got %v: %v", *timeout, err)
+	}
+}
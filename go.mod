@@ -4,6 +4,7 @@ go 1.23
 
 require (
 	github.com/fsnotify/fsnotify v1.8.0
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/spf13/pflag v1.0.6